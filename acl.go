@@ -0,0 +1,356 @@
+package bec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UnauthorizedError happens when a moderation or key record has been created by an author, who had no
+// moderation rights over a collection at that point.
+var UnauthorizedError = fmt.Errorf("author is not authorized to moderate the collection")
+
+const (
+	aclGrant  byte = 1
+	aclRevoke byte = 2
+	aclKey    byte = 3 // sharing a collection key, which is not encoded in moderation records
+)
+
+// aclOp is a moderation operation decoded from a Record.
+type aclOp struct {
+	record *Record
+	op     byte     // either aclGrant, aclRevoke or aclKey
+	mod    AuthorId // moderator, whose permissions are being granted or revoked, nil for aclKey
+}
+
+// encodeACL encodes data of a moderation record. Moderation records are told apart from user data by
+// their kind and moderate the collection they belong to.
+func encodeACL(op byte, mod AuthorId) []byte {
+	return append([]byte{op}, mod...)
+}
+
+// decodeACL returns a moderation operation carried by a given Record, or false if it's not a moderation record.
+func decodeACL(rec *Record) (*aclOp, bool) {
+	if rec.version < RecordV5 || rec.kind != kindACL {
+		return nil, false
+	}
+	if len(rec.data) != 1+ed25519.PublicKeySize || (rec.data[0] != aclGrant && rec.data[0] != aclRevoke) {
+		return nil, false
+	}
+	return &aclOp{record: rec, op: rec.data[0], mod: rec.data[1:]}, true
+}
+
+// decodeModeration returns an operation requiring moderation rights performed by a given Record. Apart
+// from moderation records, these are key records, so that only moderators can share collection keys.
+func decodeModeration(rec *Record) (*aclOp, bool) {
+	if op, ok := decodeACL(rec); ok {
+		return op, true
+	}
+	if _, ok := decodeKeyShare(rec); ok {
+		return &aclOp{record: rec, op: aclKey}, true
+	}
+	return nil, false
+}
+
+// ownedSeparator separates the hex encoded owner from the rest of the name of an owned collection.
+const ownedSeparator = "/"
+
+// OwnedCollection returns a name of a collection owned by a given author. Every peer accepts the
+// author as the owner of such collection without being configured by WithOwner.
+func OwnedCollection(owner AuthorId, name string) string {
+	return hex.EncodeToString(owner) + ownedSeparator + name
+}
+
+// ownerOf returns the owner bound to the name of a collection by OwnedCollection, or nil if there's none.
+func ownerOf(name string) AuthorId {
+	prefix, _, ok := strings.Cut(name, ownedSeparator)
+	if !ok || len(prefix) != 2*ed25519.PublicKeySize {
+		return nil
+	}
+	owner, err := hex.DecodeString(prefix)
+	if err != nil {
+		return nil
+	}
+	return owner
+}
+
+// WithOwner makes Peer accept a given author as the owner of a collection with a given name, taking
+// precedence over the owner bound to the name by OwnedCollection. Moderation and key records of
+// collections without an owner are rejected, so every replica configured the same way accepts the
+// same ones.
+func WithOwner(name string, owner AuthorId) PeerOption {
+	return func(p *Peer) {
+		if p.owners == nil {
+			p.owners = make(map[string]AuthorId)
+		}
+		p.owners[name] = owner
+	}
+}
+
+// aclView evaluates moderation operations of a single collection.
+//
+// Key records are treated as moderation records, since only moderators can share collection keys.
+//
+// Collection owner has moderation rights that cannot be revoked. Owner is either configured by
+// WithOwner or bound to the collection name by OwnedCollection, so that it doesn't depend on the
+// order in which records are received. Collections without an owner have no moderators. Other
+// authors have moderation rights once granted by the owner or another moderator, until they get
+// revoked. Revocation cancels all grants of a given moderator, which happened before or
+// concurrently to it, as well as all grants issued by that moderator concurrently to the
+// revocation. Revocations always take effect if their author was authorized in their causal past,
+// so that two moderators concurrently revoking each other both lose their rights.
+type aclView struct {
+	ops   []*aclOp                       // moderation operations in causal order
+	past  map[*aclOp]map[*aclOp]struct{} // operations which happened strictly before a given one
+	owner AuthorId                       // collection owner, nil if the collection has none
+	auth  map[*aclOp]bool                // memoized results of authorized
+	valid map[*aclOp]bool                // memoized results of isValid
+}
+
+// push adds an operation, which happened after given ones.
+func (v *aclView) push(op *aclOp, past map[*aclOp]struct{}) {
+	v.ops = append(v.ops, op)
+	v.past[op] = past
+	v.valid = make(map[*aclOp]bool) // validity depends on concurrent operations as well
+}
+
+// pop removes the operation added last by push.
+func (v *aclView) pop() {
+	op := v.ops[len(v.ops)-1]
+	v.ops = v.ops[:len(v.ops)-1]
+	delete(v.past, op)
+	delete(v.auth, op)
+	v.valid = make(map[*aclOp]bool)
+}
+
+// aclIndex keeps moderation operations of all collections up to date as records get committed, so
+// that checking a new moderation record doesn't need to traverse the store.
+type aclIndex struct {
+	views  map[string]*aclView // moderation operations by names of their collections
+	latest map[string][]*aclOp // latest operations preceding a record or equal to it, by hex encoded record ids
+	owners map[string]AuthorId // configured owners by names of their collections
+}
+
+// newACLIndex returns an empty index of moderation operations. Records are added to it in their causal order.
+func newACLIndex(owners map[string]AuthorId) *aclIndex {
+	return &aclIndex{
+		views:  make(map[string]*aclView),
+		latest: make(map[string][]*aclOp),
+		owners: owners,
+	}
+}
+
+// view returns moderation operations of a collection with a given name.
+func (idx *aclIndex) view(name string) *aclView {
+	v, ok := idx.views[name]
+	if !ok {
+		owner, configured := idx.owners[name]
+		if !configured {
+			owner = ownerOf(name)
+		}
+		v = &aclView{
+			past:  make(map[*aclOp]map[*aclOp]struct{}),
+			owner: owner,
+			auth:  make(map[*aclOp]bool),
+			valid: make(map[*aclOp]bool),
+		}
+		idx.views[name] = v
+	}
+	return v
+}
+
+// preceding returns all operations of a collection with a given name, which precede given records.
+func (idx *aclIndex) preceding(name string, deps []ID) map[*aclOp]struct{} {
+	v := idx.view(name)
+	res := make(map[*aclOp]struct{})
+	for _, dep := range deps {
+		for _, op := range idx.latest[hex.EncodeToString(dep)] {
+			res[op] = struct{}{}
+			for o := range v.past[op] {
+				res[o] = struct{}{}
+			}
+		}
+	}
+	return res
+}
+
+// check verifies that a moderation or key record has been created by an author with moderation
+// permissions at that point. Other records are always accepted.
+func (idx *aclIndex) check(r *Record) error {
+	op, ok := decodeModeration(r)
+	if !ok {
+		if r.version >= RecordV5 && (r.kind == kindACL || r.kind == kindKey) {
+			return fmt.Errorf("%w: invalid moderation record %s", MalformedMessageError, hex.EncodeToString(r.id))
+		}
+		return nil
+	}
+	v := idx.view(r.coll)
+	v.push(op, idx.preceding(r.coll, r.deps))
+	defer v.pop()
+	if !v.authorized(op) {
+		return UnauthorizedError
+	}
+	return nil
+}
+
+// add indexes a committed record.
+func (idx *aclIndex) add(r *Record) {
+	key := hex.EncodeToString(r.id)
+	if op, ok := decodeModeration(r); ok {
+		idx.view(r.coll).push(op, idx.preceding(r.coll, r.deps))
+		idx.latest[key] = []*aclOp{op}
+		return
+	}
+	// latest operations preceding a record are the latest ones among the ones preceding its dependencies
+	var candidates []*aclOp
+	seen := make(map[*aclOp]struct{})
+	for _, dep := range r.deps {
+		for _, op := range idx.latest[hex.EncodeToString(dep)] {
+			if _, ok := seen[op]; !ok {
+				seen[op] = struct{}{}
+				candidates = append(candidates, op)
+			}
+		}
+	}
+	v := idx.view(r.coll)
+	var latest []*aclOp
+	for _, op := range candidates {
+		succeeded := false
+		for _, o := range candidates {
+			if v.before(op, o) {
+				succeeded = true
+				break
+			}
+		}
+		if !succeeded {
+			latest = append(latest, op)
+		}
+	}
+	if len(latest) > 0 {
+		idx.latest[key] = latest
+	}
+}
+
+// before checks if operation a happened before b.
+func (v *aclView) before(a *aclOp, b *aclOp) bool {
+	_, ok := v.past[b][a]
+	return ok
+}
+
+func (v *aclView) concurrent(a *aclOp, b *aclOp) bool {
+	return a != b && !v.before(a, b) && !v.before(b, a)
+}
+
+// pastOps returns operations which happened strictly before op.
+func (v *aclView) pastOps(op *aclOp) []*aclOp {
+	var res []*aclOp
+	for _, o := range v.ops {
+		if v.before(o, op) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// notAfter returns all operations other than op itself, which didn't happen after op.
+func (v *aclView) notAfter(op *aclOp) []*aclOp {
+	var res []*aclOp
+	for _, o := range v.ops {
+		if o != op && !v.before(op, o) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// permitted checks if operation is allowed at all, given the collection owner.
+func permitted(op *aclOp, owner AuthorId) bool {
+	return op.op != aclRevoke || !bytes.Equal(op.mod, owner) // owner cannot be revoked
+}
+
+// authorized checks if author of op had moderation rights given only the causal past of op.
+// This can be decided as soon as op is about to be integrated.
+func (v *aclView) authorized(op *aclOp) bool {
+	if res, ok := v.auth[op]; ok {
+		return res
+	}
+	past := v.pastOps(op)
+	res := permitted(op, v.owner) && v.rights(op.record.author, past, past, v.authorized)
+	v.auth[op] = res
+	return res
+}
+
+// isValid checks if op is valid given all operations known to the view, i.e. it is authorized and
+// there was no concurrent revocation of its author's rights.
+func (v *aclView) isValid(op *aclOp) bool {
+	if res, ok := v.valid[op]; ok {
+		return res
+	}
+	res := permitted(op, v.owner) &&
+		v.rights(op.record.author, v.pastOps(op), v.notAfter(op), v.isValid)
+	v.valid[op] = res
+	return res
+}
+
+// rights checks if author has moderation rights, given the grants (accepted only if `counts` them)
+// and revocations known at this point.
+func (v *aclView) rights(author AuthorId, grants []*aclOp, revokes []*aclOp, counts func(*aclOp) bool) bool {
+	if bytes.Equal(author, v.owner) {
+		return true
+	}
+	for _, g := range grants {
+		if g.op == aclGrant && bytes.Equal(g.mod, author) && counts(g) && !v.revoked(g, revokes) {
+			return true
+		}
+	}
+	return false
+}
+
+// revoked checks if grant g has been cancelled by any of the effective revocations.
+func (v *aclView) revoked(g *aclOp, revokes []*aclOp) bool {
+	for _, r := range revokes {
+		if r.op != aclRevoke || !v.authorized(r) {
+			continue
+		}
+		if bytes.Equal(r.mod, g.mod) && !v.before(r, g) {
+			return true // moderator has been revoked before or concurrently to being granted
+		}
+		if bytes.Equal(r.mod, g.record.author) && v.concurrent(r, g) {
+			return true // grant was issued concurrently to revoking its author
+		}
+	}
+	return false
+}
+
+// moderators returns all authors with moderation rights.
+func (v *aclView) moderators() []AuthorId {
+	if v.owner == nil {
+		return nil
+	}
+	res := []AuthorId{v.owner}
+	seen := map[string]struct{}{hex.EncodeToString(v.owner): {}}
+	for _, g := range v.ops {
+		key := hex.EncodeToString(g.mod)
+		if _, ok := seen[key]; ok || g.op != aclGrant {
+			continue
+		}
+		if v.rights(g.mod, v.ops, v.ops, v.isValid) {
+			seen[key] = struct{}{}
+			res = append(res, g.mod)
+		}
+	}
+	return res
+}
+
+// rejected returns identifiers of all invalid operations.
+func (v *aclView) rejected() []ID {
+	var res []ID
+	for _, op := range v.ops {
+		if !v.isValid(op) {
+			res = append(res, op.record.id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,191 @@
+package bec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+// exchange makes both peers integrate all records of each other.
+func exchange(t *testing.T, a *Peer, b *Peer) {
+	if err := reconcileV1(a, b); err != nil {
+		t.Fatalf("failed to reconcile: %s", err.Error())
+	}
+	if err := reconcileV1(b, a); err != nil {
+		t.Fatalf("failed to reconcile: %s", err.Error())
+	}
+}
+
+func sameAuthors(a []AuthorId, b []AuthorId) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGrantRevoke(t *testing.T) {
+	owner := newTestPeer(t)
+	mod := newTestPeer(t)
+	other := newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+
+	if err := owner.Grant(c, mod.pub); err != nil {
+		t.Fatalf("owner failed to grant: %s", err.Error())
+	}
+	exchange(t, owner, mod)
+	if err := mod.Grant(c, other.pub); err != nil {
+		t.Fatalf("moderator failed to grant: %s", err.Error())
+	}
+	exchange(t, owner, mod)
+	exchange(t, mod, other)
+
+	if !sameAuthors(owner.Moderators(c), []AuthorId{owner.pub, mod.pub, other.pub}) {
+		t.Fatalf("unexpected moderators")
+	}
+	if err := other.Revoke(c, mod.pub); err != nil {
+		t.Fatalf("moderator failed to revoke: %s", err.Error())
+	}
+	if err := other.Revoke(c, owner.pub); err != UnauthorizedError {
+		t.Fatalf("expected owner revocation to fail, got: %v", err)
+	}
+	exchange(t, owner, other)
+	if !sameAuthors(owner.Moderators(c), []AuthorId{owner.pub, other.pub}) {
+		t.Fatalf("unexpected moderators after revocation")
+	}
+}
+
+// moderation creates a moderation record of a given peer without checking its permissions.
+func moderation(p *Peer, op byte, name string, mod AuthorId) *Record {
+	r := &Record{version: LatestRecordVersion, author: p.pub, deps: p.HeadsOf(name), coll: name,
+		kind: kindACL, data: encodeACL(op, mod)}
+	return r.seal(p.priv)
+}
+
+func TestGrantUnauthorized(t *testing.T) {
+	owner := newTestPeer(t)
+	mod := newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+
+	if err := owner.Grant(c, owner.pub); err != nil {
+		t.Fatalf("owner failed to grant: %s", err.Error())
+	}
+	exchange(t, owner, mod)
+	if err := mod.Grant(c, mod.pub); err != UnauthorizedError {
+		t.Fatalf("expected grant to fail, got: %v", err)
+	}
+
+	// forged moderation record is rejected by other peers as well
+	forged := moderation(mod, aclGrant, c, mod.pub)
+	if err := owner.Integrate([]*Record{forged}); err != UnauthorizedError {
+		t.Fatalf("expected forged grant to be rejected, got: %v", err)
+	}
+}
+
+func TestRevokeConcurrentGrant(t *testing.T) {
+	owner := newTestPeer(t)
+	mod := newTestPeer(t)
+	other := newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+
+	if err := owner.Grant(c, mod.pub); err != nil {
+		t.Fatalf("owner failed to grant: %s", err.Error())
+	}
+	exchange(t, owner, mod)
+
+	// owner revokes moderator, while it concurrently grants permissions to someone else
+	if err := owner.Revoke(c, mod.pub); err != nil {
+		t.Fatalf("owner failed to revoke: %s", err.Error())
+	}
+	if err := mod.Grant(c, other.pub); err != nil {
+		t.Fatalf("moderator failed to grant: %s", err.Error())
+	}
+	grant := mod.Heads()[0]
+	exchange(t, owner, mod)
+
+	for _, p := range []*Peer{owner, mod} {
+		if !sameAuthors(p.Moderators(c), []AuthorId{owner.pub}) {
+			t.Fatalf("expected only owner to remain a moderator")
+		}
+		rejected := p.Rejected(c)
+		if len(rejected) != 1 || !bytes.Equal(rejected[0], grant) {
+			t.Fatalf("expected concurrent grant to be rejected")
+		}
+	}
+
+	// once revocation is known, moderator cannot grant anymore
+	if err := mod.Grant(c, other.pub); err != UnauthorizedError {
+		t.Fatalf("expected grant to fail after revocation, got: %v", err)
+	}
+	forged := moderation(mod, aclGrant, c, other.pub)
+	if err := owner.Integrate([]*Record{forged}); err != UnauthorizedError {
+		t.Fatalf("expected grant issued after revocation to be rejected, got: %v", err)
+	}
+}
+
+func TestCollectionOwner(t *testing.T) {
+	a, b := newTestPeer(t), newTestPeer(t)
+	if err := a.Grant("c", b.pub); err != UnauthorizedError {
+		t.Fatalf("expected collections without an owner to have no moderators, got: %v", err)
+	}
+
+	// replicas receiving competing root grants in different order agree on the owner
+	c := OwnedCollection(a.pub, "c")
+	fromA := moderation(a, aclGrant, c, a.pub)
+	fromB := moderation(b, aclGrant, c, b.pub)
+	for _, order := range [][]*Record{{fromA, fromB}, {fromB, fromA}} {
+		p := newTestPeer(t)
+		for _, r := range order {
+			err := p.Integrate([]*Record{r})
+			if r == fromB && err != UnauthorizedError {
+				t.Fatalf("expected grant of another author to be rejected, got: %v", err)
+			} else if r == fromA && err != nil {
+				t.Fatalf("expected grant of the owner to be accepted, got: %v", err)
+			}
+		}
+		if !sameAuthors(p.Moderators(c), []AuthorId{a.pub}) {
+			t.Fatalf("unexpected moderators: %v", p.Moderators(c))
+		}
+	}
+
+	// configured owner takes precedence over the one bound to the name
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, NewMemStore(), WithOwner(c, b.pub))
+	if err = p.Integrate([]*Record{fromA}); err != UnauthorizedError {
+		t.Fatalf("expected configured owner to take precedence, got: %v", err)
+	}
+	if err = p.Integrate([]*Record{fromB}); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestModerationKind(t *testing.T) {
+	owner, other := newTestPeer(t), newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+	if _, err := other.CommitTo(c, encodeACL(aclGrant, other.pub)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if mods := other.Moderators(c); !sameAuthors(mods, []AuthorId{owner.pub}) {
+		t.Fatalf("expected user data not to be mistaken for moderation records, got: %v", mods)
+	}
+	if err := owner.Grant(c, other.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// index of moderation records is rebuilt from the store
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, owner.store)
+	if !sameAuthors(p.Moderators(c), []AuthorId{owner.pub, other.pub}) {
+		t.Fatalf("unexpected moderators: %v", p.Moderators(c))
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/crc32"
-	"math"
 	"strings"
 )
 
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type Bitmap []byte
 
 func NewBitmap(n int) Bitmap {
@@ -54,12 +55,43 @@ func (b Bitmap) Equals(o Bitmap) bool {
 	return bytes.Compare(b, o) == 0
 }
 
+// NewBloom returns an empty Bitmap sized to serve as a Bloom filter for n entries.
+func NewBloom(n int) Bitmap {
+	if n < 1 {
+		n = 1
+	}
+	return NewBitmap(n * BloomBitsPerEntry)
+}
+
+// AddBloom sets a given id in a Bitmap used as a Bloom filter.
 func (b Bitmap) AddBloom(id ID, hashes int) {
-	c := append(id, 0)
-	l := b.Len()
-	for i := 0; i < hashes; i++ {
-		h := crc32.ChecksumIEEE(c) & math.MaxInt32
-		b.Set(int(h)%l, true)
-		c[l] = byte(i)
+	l := uint64(b.Len())
+	h1, h2 := bloomHashes(id)
+	for i := uint64(0); i < uint64(hashes); i++ {
+		b.Set(int((h1+i*h2)%l), true)
 	}
 }
+
+// HasBloom checks if a given id may have been added to a Bitmap used as a Bloom filter.
+// It can return false positives, but never false negatives.
+func (b Bitmap) HasBloom(id ID, hashes int) bool {
+	l := uint64(b.Len())
+	if l == 0 {
+		return false
+	}
+	h1, h2 := bloomHashes(id)
+	for i := uint64(0); i < uint64(hashes); i++ {
+		if !b.Get(int((h1 + i*h2) % l)) {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes returns two independent hashes of id. Following Kirsch-Mitzenmacher, i-th hash
+// function of a Bloom filter is simulated as h1 + i*h2.
+func bloomHashes(id ID) (uint64, uint64) {
+	h1 := crc32.ChecksumIEEE(id)
+	h2 := crc32.Checksum(id, castagnoli) | 1
+	return uint64(h1), uint64(h2)
+}
@@ -0,0 +1,117 @@
+package bec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+var (
+	// ClockSkewError happens when integrating a Record, which timestamp is too far in the future.
+	ClockSkewError = fmt.Errorf("record timestamp is too far in the future")
+
+	// TimestampOrderError happens when integrating a Record, which timestamp is not greater than
+	// timestamps of its dependencies.
+	TimestampOrderError = fmt.Errorf("record timestamp doesn't follow its dependencies")
+)
+
+// DefaultMaxClockSkew is the maximum time by which timestamps of integrated records can be ahead of the local clock.
+const DefaultMaxClockSkew = time.Minute
+
+// Timestamp is a hybrid logical clock timestamp. It follows the physical time of its creator, but
+// it's always greater than timestamps of all records it depends on, even if their clocks were ahead.
+type Timestamp struct {
+	Wall    uint64 // physical time in nanoseconds since Unix epoch
+	Logical uint32 // counter of records created within the same physical time
+}
+
+// IsZero checks if the Timestamp is not set.
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0
+}
+
+// Time returns the physical time of the Timestamp.
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, int64(t.Wall))
+}
+
+// Compare returns -1, 0 or +1 depending on whether t is before, equal or after o.
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.Wall < o.Wall:
+		return -1
+	case t.Wall > o.Wall:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	}
+	return 0
+}
+
+// Before checks if t is before o.
+func (t Timestamp) Before(o Timestamp) bool {
+	return t.Compare(o) < 0
+}
+
+// Before checks if the Record precedes a given one in a deterministic total order of records by their
+// timestamps and ids. For timestamped records the order is consistent with causality.
+func (r *Record) Before(o *Record) bool {
+	if c := r.time.Compare(o.time); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(r.id, o.id) < 0
+}
+
+// WithClock makes Peer use a given source of physical time for timestamps of its records. Records
+// which timestamps are more than skew ahead of it are not integrated.
+func WithClock(now func() time.Time, skew time.Duration) PeerOption {
+	return func(p *Peer) {
+		p.now = now
+		p.skew = skew
+	}
+}
+
+// tick returns a timestamp of a new record, which follows the current heads. Caller must hold a write lock.
+func (p *Peer) tick() Timestamp {
+	last := p.clock
+	for _, r := range p.store.GetMany(p.heads) {
+		if last.Before(r.time) {
+			last = r.time
+		}
+	}
+	if now := uint64(p.now().UnixNano()); now > last.Wall {
+		p.clock = Timestamp{Wall: now}
+	} else {
+		p.clock = Timestamp{Wall: last.Wall, Logical: last.Logical + 1}
+	}
+	return p.clock
+}
+
+// checkSkew makes sure that a timestamp of a given record is not too far ahead of the local clock.
+func (p *Peer) checkSkew(r *Record) error {
+	if r.time.IsZero() || p.skew <= 0 {
+		return nil
+	}
+	if limit := p.now().Add(p.skew); r.time.Time().After(limit) {
+		return fmt.Errorf("%w: %s created at %v", ClockSkewError, hex.EncodeToString(r.id), r.time.Time())
+	}
+	return nil
+}
+
+// checkTime makes sure that a timestamp of a given record is greater than timestamps of its
+// dependencies. Timestamps of pruned dependencies are not known, so they are not checked.
+// Caller must hold a write lock.
+func (p *Peer) checkTime(r *Record) error {
+	if r.time.IsZero() {
+		return nil
+	}
+	for _, dep := range p.store.GetMany(r.deps) {
+		if !dep.time.Before(r.time) {
+			return fmt.Errorf("%w: %s", TimestampOrderError, hex.EncodeToString(r.id))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestClockPeer(t *testing.T, now *time.Time) *Peer {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return NewPeer(pub, priv, NewMemStore(), WithClock(func() time.Time { return *now }, time.Minute))
+}
+
+// timestamped creates a record of a given peer with an arbitrary timestamp.
+func timestamped(p *Peer, seq uint64, ts Timestamp, deps []ID, data []byte) *Record {
+	r := &Record{version: LatestRecordVersion, author: p.pub, seq: seq, time: ts, deps: deps, data: data}
+	return r.seal(p.priv)
+}
+
+func TestPeerTimestamps(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := newTestClockPeer(t, &now)
+	r1, _ := a.Commit([]byte("A1"))
+	r2, _ := a.Commit([]byte("A2"))
+	if r1.Timestamp() != (Timestamp{Wall: uint64(now.UnixNano())}) || !r1.Timestamp().Before(r2.Timestamp()) {
+		t.Fatalf("expected logical clock to advance within the same physical time, got %v and %v",
+			r1.Timestamp(), r2.Timestamp())
+	}
+
+	// remote clock is ahead of ours
+	later := now.Add(30 * time.Second)
+	b := newTestClockPeer(t, &later)
+	r3, _ := b.Commit([]byte("B"))
+	if err := a.Integrate([]*Record{r3}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r4, _ := a.Commit([]byte("A3"))
+	if !r3.Timestamp().Before(r4.Timestamp()) || !r3.Before(r4) {
+		t.Fatalf("expected timestamp to follow dependencies, got %v after %v", r4.Timestamp(), r3.Timestamp())
+	}
+
+	var buf bytes.Buffer
+	if err := r4.Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := ReadRecord(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if r.Timestamp() != r4.Timestamp() {
+		t.Fatalf("timestamp was not read back")
+	}
+}
+
+func TestIntegrateTimestamps(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := newTestClockPeer(t, &now)
+	remote := newTestPeer(t)
+	ts := Timestamp{Wall: uint64(now.UnixNano())}
+
+	future := timestamped(remote, 1, Timestamp{Wall: uint64(now.Add(time.Hour).UnixNano())}, nil, []byte("A"))
+	if err := p.Integrate([]*Record{future}); !errors.Is(err, ClockSkewError) {
+		t.Fatalf("expected record from the future to be rejected, got: %v", err)
+	}
+
+	a := timestamped(remote, 1, ts, nil, []byte("A"))
+	b := timestamped(remote, 2, ts, []ID{a.id}, []byte("B"))
+	if err := p.Integrate([]*Record{b}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// b is released from the stash by a, which is not to blame for it
+	if err := p.Integrate([]*Record{a}); err != nil {
+		t.Fatalf("expected released record not to fail integration, got: %v", err)
+	}
+	if p.store.Contains(b.id) || p.StashStats().Rejected != 1 {
+		t.Fatalf("expected record not following its dependencies to be rejected")
+	}
+	c := timestamped(remote, 2, ts, []ID{a.id}, []byte("C"))
+	if err := p.Integrate([]*Record{c}); !errors.Is(err, TimestampOrderError) {
+		t.Fatalf("expected record not following its dependencies to be rejected, got: %v", err)
+	}
+}
+
+func TestRecordTotalOrder(t *testing.T) {
+	p := newTestPeer(t)
+	var records []*Record
+	for i := 0; i < 10; i++ {
+		r, err := p.Commit([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		records = append(records, r)
+	}
+	sorted := make([]*Record, len(records))
+	for i, r := range records {
+		sorted[len(records)-1-i] = r
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+	for i := range records {
+		if sorted[i] != records[i] {
+			t.Fatalf("expected total order to be consistent with causality")
+		}
+	}
+}
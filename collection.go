@@ -0,0 +1,197 @@
+package bec
+
+import "sort"
+
+// FollowCollections makes Peer replicate only collections with given names. Records of other
+// collections are not integrated. All collections are replicated otherwise.
+func FollowCollections(names ...string) PeerOption {
+	return func(p *Peer) {
+		p.interest.collections = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			p.interest.collections[name] = struct{}{}
+		}
+	}
+}
+
+// CommitTo creates a new record with given data in a collection with a given name. Collections are
+// replicated independently, so the record depends only on the current heads of its collection.
+// Committing to a collection, which is not followed, starts following it.
+func (p *Peer) CommitTo(collection string, data []byte) (*Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interest.collections != nil {
+		p.interest.collections[collection] = struct{}{}
+	}
+	c := p.next(collection, kindData, data)
+	if err := p.commit(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// HeadsOf returns heads of a collection with a given name.
+func (p *Peer) HeadsOf(collection string) []ID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.headsOf(collection)
+}
+
+// Collections returns names of all collections with records committed on this peer.
+func (p *Peer) Collections() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.collections()
+}
+
+// headsOf returns heads of a collection with a given name. Caller must hold at least a read lock.
+func (p *Peer) headsOf(collection string) []ID {
+	if s, ok := p.store.(CollectionStore); ok {
+		return s.HeadsOf(collection)
+	}
+	// records depend only on records of their own collection, so its heads are the heads of the whole
+	// store belonging to it
+	heads := []ID{}
+	for _, r := range p.store.GetMany(p.store.Heads()) {
+		if r.coll == collection {
+			heads = append(heads, r.id)
+		}
+	}
+	return heads
+}
+
+// collections returns names of all collections with committed records. Caller must hold at least a read lock.
+func (p *Peer) collections() []string {
+	if s, ok := p.store.(CollectionStore); ok {
+		return s.Collections()
+	}
+	return sortedNames(p.derived.collections)
+}
+
+// Follow makes Peer replicate a collection with a given name.
+func (p *Peer) Follow(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interest.collections != nil {
+		p.interest.collections[name] = struct{}{}
+	}
+}
+
+// Unfollow makes Peer stop replicating a collection with a given name. Its records, which have
+// been already committed, are kept. If Peer has been following all collections so far, it keeps
+// following the other ones it knows about.
+func (p *Peer) Unfollow(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interest.collections == nil {
+		p.interest.collections = make(map[string]struct{})
+		for _, c := range p.collections() {
+			p.interest.collections[c] = struct{}{}
+		}
+	}
+	delete(p.interest.collections, name)
+}
+
+// Following returns names of collections replicated by this peer in lexicographical order, or nil
+// if all collections are replicated.
+func (p *Peer) Following() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.interest.collections == nil {
+		return nil
+	}
+	return sortedNames(p.interest.collections)
+}
+
+// announceTo returns heads of collections replicated by both this peer and a remote one, which
+// follows given collections. nil means all collections.
+func (p *Peer) announceTo(collections map[string]struct{}) []ID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.announceLocked(collections)
+}
+
+// announceLocked is announceTo for callers, which already hold at least a read lock.
+func (p *Peer) announceLocked(collections map[string]struct{}) []ID {
+	if p.interest.collections == nil && collections == nil {
+		return p.heads
+	}
+	names := p.interest.collections
+	if names == nil {
+		names = collections
+	}
+	heads := []ID{}
+	for _, name := range sortedNames(names) {
+		if _, ok := collections[name]; ok || collections == nil {
+			if p.interest.follows(name) {
+				heads = append(heads, p.headsOf(name)...)
+			}
+		}
+	}
+	return heads
+}
+
+func sortedNames(names map[string]struct{}) []string {
+	res := make([]string, 0, len(names))
+	for name := range names {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// CommitTo creates a new record in a collection with a given name on the underlying Peer and
+// announces new heads to all connected remotes following that collection.
+func (c *PeerController) CommitTo(collection string, data []byte) (*Record, error) {
+	var r *Record
+	err := c.Do(func(p *Peer) error {
+		var err error
+		r, err = p.CommitTo(collection, data)
+		if err != nil {
+			return err
+		}
+		c.announce()
+		return nil
+	})
+	return r, err
+}
+
+// Follow makes the underlying Peer replicate a collection with a given name, letting all connected
+// remotes know about it and synchronizing with them, so that records of that collection are fetched.
+func (c *PeerController) Follow(name string) error {
+	return c.Do(func(p *Peer) error {
+		p.Follow(name)
+		c.broadcast(encodeInterest(p.Interest()))
+		for _, r := range c.remotes() {
+			c.sync(r, r.known)
+		}
+		return nil
+	})
+}
+
+// Unfollow makes the underlying Peer stop replicating a collection with a given name, letting all
+// connected remotes know about it.
+func (c *PeerController) Unfollow(name string) error {
+	return c.Do(func(p *Peer) error {
+		p.Unfollow(name)
+		c.broadcast(encodeInterest(p.Interest()))
+		return nil
+	})
+}
+
+// announce sends current heads to all connected remotes, limited to the collections they follow.
+func (c *PeerController) announce() {
+	for _, r := range c.remotes() {
+		r.send(encodeIDs(MsgAnnounce, c.peer.announceTo(r.interest.collections)))
+	}
+}
+
+// remotes returns all connected remotes.
+func (c *PeerController) remotes() []*remote {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]*remote, 0, len(c.out))
+	for _, r := range c.out {
+		res = append(res, r)
+	}
+	return res
+}
@@ -0,0 +1,143 @@
+package bec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestPeerCollections(t *testing.T) {
+	p := newTestPeer(t)
+	a1, _ := p.CommitTo("a", []byte("A1"))
+	b1, _ := p.CommitTo("b", []byte("B1"))
+	a2, _ := p.CommitTo("a", []byte("A2"))
+	if !sameIDs(p.HeadsOf("a"), []ID{a2.id}) || !sameIDs(p.HeadsOf("b"), []ID{b1.id}) {
+		t.Fatalf("expected heads to be tracked per collection")
+	}
+	if !sameIDs(a2.deps, []ID{a1.id}) || len(b1.deps) != 0 {
+		t.Fatalf("expected records to depend only on records of their collection")
+	}
+	if a2.Seq() != 2 || b1.Seq() != 1 {
+		t.Fatalf("expected records to be sequenced per collection, got %d and %d", a2.Seq(), b1.Seq())
+	}
+	if p.store.(*MemStore).Equivocated(p.pub) {
+		t.Fatalf("records in different collections are not concurrent")
+	}
+	if names := p.Collections(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("unexpected collections: %v", names)
+	}
+
+	mixed := NewRecord(p.pub, p.priv, []ID{a2.id}, []byte("mixed"))
+	if err := p.Integrate([]*Record{mixed}); err != CollectionMismatchError {
+		t.Fatalf("expected record depending on another collection to be rejected, got: %v", err)
+	}
+}
+
+func TestPeerBasicStore(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// embedding the interface hides all optional methods of the MemStore
+	store := struct{ Store }{NewMemStore()}
+	p := NewPeer(pub, priv, store)
+	a1, _ := p.CommitTo("a", []byte("A1"))
+	b1, _ := p.CommitTo("b", []byte("B1"))
+	a2, _ := p.CommitTo("a", []byte("A2"))
+	if !sameIDs(p.HeadsOf("a"), []ID{a2.id}) || !sameIDs(a2.deps, []ID{a1.id}) || a2.Seq() != 2 {
+		t.Fatalf("expected heads and sequence numbers to be derived per collection")
+	}
+	if names := p.Collections(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("unexpected collections: %v", names)
+	}
+
+	// indexes are rebuilt from the store
+	p = NewPeer(pub, priv, store)
+	if b2, _ := p.CommitTo("b", []byte("B2")); b2.Seq() != 2 || !sameIDs(b2.deps, []ID{b1.id}) {
+		t.Fatalf("expected a reopened peer to continue its chain")
+	}
+	s, err := p.Snapshot(p.HeadsOf("a"), nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = p.Prune(s); err != PruningUnsupportedError {
+		t.Fatalf("expected pruning to be unsupported, got: %v", err)
+	}
+}
+
+func TestPeerFollow(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, NewMemStore(), FollowCollections("a"))
+	remote := newTestPeer(t)
+	a, _ := remote.CommitTo("a", []byte("A"))
+	b, _ := remote.CommitTo("b", []byte("B"))
+	if err := p.Integrate([]*Record{a, b}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !p.store.Contains(a.id) || p.store.Contains(b.id) {
+		t.Fatalf("expected only records of followed collections to be integrated")
+	}
+	if !sameIDs(p.Announce(), []ID{a.id}) {
+		t.Fatalf("expected heads of followed collections to be announced")
+	}
+
+	p.Follow("b")
+	p.Unfollow("a")
+	if names := p.Following(); len(names) != 1 || names[0] != "b" {
+		t.Fatalf("unexpected followed collections: %v", names)
+	}
+	if err := p.Integrate([]*Record{b}); err != nil || !p.store.Contains(b.id) {
+		t.Fatalf("expected newly followed collection to be integrated, got: %v", err)
+	}
+}
+
+func TestControllerFollow(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p2 := NewPeer(pub, priv, NewMemStore(), FollowCollections("a"))
+	c1, c2 := NewController(newTestPeer(t)), NewController(p2)
+	go c1.Process()
+	go c2.Process()
+	defer c1.Close()
+	defer c2.Close()
+	a, err := c1.CommitTo("a", []byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	b, err := c1.CommitTo("b", []byte("B"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	link(t, c1, "c1", c2, "c2")
+	eventually(t, "followed collection is replicated", func() bool {
+		return sameIDs(heads(t, c2), []ID{a.id})
+	})
+
+	a2, err := c1.CommitTo("a", []byte("A2"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = c1.CommitTo("b", []byte("B2")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	eventually(t, "new records of followed collection are replicated", func() bool {
+		return sameIDs(heads(t, c2), []ID{a2.id})
+	})
+	time.Sleep(50 * time.Millisecond)
+	if p2.store.Contains(b.id) {
+		t.Fatalf("expected records of other collections not to be replicated")
+	}
+
+	if err = c2.Follow("b"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	eventually(t, "newly followed collection is replicated", func() bool {
+		return sameIDs(heads(t, c1), heads(t, c2))
+	})
+}
@@ -0,0 +1,524 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	MsgAnnounce = iota
+	MsgRequest
+	MsgRecords
+	MsgSync     // heads followed by a Bloom filter of records added since the last synchronization
+	MsgInterest // records replicated by the sender, see Interest
+	MsgPartial  // records interleaved with skeletons of the ones, which the receiver is not interested in
+	MsgMore     // part of records and skeletons encoded like MsgPartial, followed by the rest of the reply
+)
+
+var (
+	// ControllerClosedError is returned when operation was requested on a PeerController which has been closed.
+	ControllerClosedError = fmt.Errorf("peer controller has been closed")
+
+	// MalformedMessageError happens when a message received from a remote peer could not be decoded.
+	MalformedMessageError = fmt.Errorf("malformed message")
+)
+
+// message is a payload received from a remote peer identified by its name.
+type message struct {
+	from string
+	data []byte
+}
+
+// remote is a connection to a remote peer. Outgoing messages are queued, so that
+// PeerController.Process never blocks on a slow or unresponsive remote.
+type remote struct {
+	name  string
+	out   chan<- []byte
+	mu    sync.Mutex
+	queue [][]byte      // messages waiting to be sent over out
+	ready chan struct{} // signals that queue is not empty
+	done  chan struct{} // closed once remote has been disconnected
+	known []ID          // heads known to be present on both sides, owned by PeerController.Process loop
+	heads []ID          // latest heads announced by remote, owned by PeerController.Process loop
+	rep   Reputation    // misbehaviour of remote over this connection, guarded by PeerController.mu
+
+	requested map[string]struct{} // ids of records requested from remote, owned by PeerController.Process loop
+	syncs     int                 // number of sync messages sent to remote and not replied yet, owned by PeerController.Process loop
+	interest  interest            // records replicated by remote, owned by PeerController.Process loop
+}
+
+func newRemote(name string, out chan<- []byte) *remote {
+	return &remote{
+		name:      name,
+		out:       out,
+		requested: make(map[string]struct{}),
+		ready:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+func (r *remote) send(msg []byte) {
+	r.mu.Lock()
+	r.queue = append(r.queue, msg)
+	r.mu.Unlock()
+	select {
+	case r.ready <- struct{}{}:
+	default: // writer has been already notified
+	}
+}
+
+// writeLoop drains the queue into out channel, closing it once the remote gets disconnected.
+func (r *remote) writeLoop() {
+	defer close(r.out)
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.ready:
+			r.mu.Lock()
+			q := r.queue
+			r.queue = nil
+			r.mu.Unlock()
+			for _, msg := range q {
+				select {
+				case r.out <- msg:
+				case <-r.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// PeerController runs the replication protocol of a Peer against connected remote peers.
+// All operations on the underlying Peer are serialized by PeerController.Process loop.
+type PeerController struct {
+	in         chan message
+	ops        chan func()
+	mu         sync.Mutex
+	out        map[string]*remote
+	peer       *Peer
+	limits     Limits             // limits applied when decoding messages from remote peers
+	maxSize    int                // maximum size of records messages sent to remote peers
+	reputation ReputationConfig   // penalties of misbehaving remote peers
+	gossip     *GossipConfig      // if not nil, controller periodically synchronizes with picked remotes
+	health     map[string]*health // health of remotes by their names, guarded by mu
+	now        func() time.Time   // clock used to track health of remotes
+	rand       *rand.Rand         // randomness used by gossip, owned by Process loop
+	done       chan struct{}
+	close      sync.Once
+}
+
+// ControllerOption configures an optional PeerController behaviour.
+type ControllerOption func(c *PeerController)
+
+// WithLimits sets the Limits used to decode messages from remote peers. DefaultLimits are used otherwise.
+// Records sent to remote peers are split into messages within the same MaxRecords limit.
+func WithLimits(l Limits) ControllerOption {
+	return func(c *PeerController) {
+		c.limits = l
+	}
+}
+
+// WithMaxMessageSize sets the maximum size of messages with records sent to remote peers, which must
+// not exceed MaxFrameSize of their transports. DefaultMaxFrameSize is used otherwise.
+func WithMaxMessageSize(size int) ControllerOption {
+	return func(c *PeerController) {
+		c.maxSize = size
+	}
+}
+
+func NewController(p *Peer, opts ...ControllerOption) *PeerController {
+	c := &PeerController{
+		in:         make(chan message),
+		ops:        make(chan func()),
+		out:        make(map[string]*remote),
+		peer:       p,
+		limits:     DefaultLimits,
+		maxSize:    DefaultMaxFrameSize,
+		reputation: DefaultReputationConfig,
+		health:     make(map[string]*health),
+		now:        time.Now,
+		rand:       newRand(),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Connect attaches a remote peer under given name. Messages from that peer are read from `in`,
+// while replies are sent over `out`. Controller takes ownership of `out` and closes it once
+// remote gets disconnected. Remote is disconnected automatically once `in` is closed.
+// Right after connecting, current peer heads are announced to the remote using Bloom filter based
+// reconciliation: remote replies with all records, it has reasons to believe we don't have.
+// If the peer replicates only some records, remote is told about its Interest first, so that it
+// doesn't send records the peer is not interested in.
+// Connected remote becomes a replica tracked by the peer for the purpose of causal stability.
+// Banned remotes cannot be connected until their ban is over.
+func (c *PeerController) Connect(name string, in <-chan []byte, out chan<- []byte) error {
+	r := newRemote(name, out)
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return ControllerClosedError
+	default:
+	}
+	if c.banned(name) {
+		c.mu.Unlock()
+		return BannedError
+	}
+	c.peer.track(name)
+	if old, ok := c.out[name]; ok {
+		close(old.done)
+	}
+	c.out[name] = r
+	c.mu.Unlock()
+
+	go r.writeLoop()
+	go func() {
+		defer c.detach(r)
+		for {
+			select {
+			case data, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case c.in <- message{from: name, data: data}:
+				case <-r.done:
+					return
+				case <-c.done:
+					return
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	// Process loop may not be running yet, so don't wait for the announcement to be sent
+	go c.Do(func(p *Peer) error {
+		if in := p.Interest(); in.Collections != nil || in.Authors != nil {
+			// remotes assume that all records are replicated, unless told otherwise
+			r.send(encodeInterest(in))
+		}
+		c.sync(r, r.known)
+		return nil
+	})
+	return nil
+}
+
+// Disconnect detaches remote peer with a given name. It's a no-op if no such remote was connected.
+func (c *PeerController) Disconnect(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnect(name)
+}
+
+// detach disconnects r, unless it has been already replaced by another connection with the same name.
+func (c *PeerController) detach(r *remote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.out[r.name] == r {
+		c.disconnect(r.name)
+	}
+}
+
+func (c *PeerController) disconnect(name string) {
+	if r, ok := c.out[name]; ok {
+		delete(c.out, name)
+		close(r.done)
+	}
+}
+
+// Remotes returns names of all currently connected remote peers.
+func (c *PeerController) Remotes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]string, 0, len(c.out))
+	for name := range c.out {
+		res = append(res, name)
+	}
+	return res
+}
+
+// Do executes f on the Process loop, giving it exclusive access to the underlying Peer.
+// It blocks until f completes and returns its error.
+func (c *PeerController) Do(f func(p *Peer) error) error {
+	res := make(chan error, 1)
+	op := func() { res <- f(c.peer) }
+	select {
+	case c.ops <- op:
+	case <-c.done:
+		return ControllerClosedError
+	}
+	return <-res
+}
+
+// Commit creates a new record in the default collection on the underlying Peer and announces new
+// heads to all connected remotes.
+func (c *PeerController) Commit(data []byte) (*Record, error) {
+	return c.CommitTo("", data)
+}
+
+// Announce sends current peer heads to all connected remotes.
+func (c *PeerController) Announce() error {
+	return c.Do(func(p *Peer) error {
+		c.announce()
+		return nil
+	})
+}
+
+func (c *PeerController) broadcast(msg []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.out {
+		r.send(msg)
+	}
+}
+
+func (c *PeerController) sendTo(name string, msg []byte) {
+	if r, ok := c.remote(name); ok {
+		r.send(msg)
+	}
+}
+
+// remote returns a connected remote with a given name.
+func (c *PeerController) remote(name string) (*remote, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.out[name]
+	return r, ok
+}
+
+// request asks a remote with a given name for records with given ids.
+func (c *PeerController) request(name string, ids []ID) {
+	if r, ok := c.remote(name); ok {
+		r.expect(ids)
+		r.send(encodeIDs(MsgRequest, ids))
+	}
+}
+
+// serve sends records to a remote, split into messages within the limits of the remote.
+func (c *PeerController) serve(r *remote, records []*Record) {
+	for _, msg := range encodeServed(records, c.limits.MaxRecords, c.maxSize) {
+		r.send(msg)
+	}
+}
+
+// sync sends current heads to a remote together with a Bloom filter of records added since
+// `known` heads. Remote replies with records, which we're likely missing.
+func (c *PeerController) sync(r *remote, known []ID) {
+	r.syncs++
+	r.send(encodeSync(c.peer, c.peer.announceTo(r.interest.collections), known))
+}
+
+// Close stops the Process loop and disconnects all remotes.
+func (c *PeerController) Close() error {
+	c.close.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		close(c.done)
+		for name := range c.out {
+			c.disconnect(name)
+		}
+	})
+	return nil
+}
+
+// Process runs the controller loop, handling incoming messages until controller is closed.
+func (c *PeerController) Process() error {
+	var tick <-chan time.Time
+	if c.gossip != nil && c.gossip.Interval > 0 {
+		ticker := time.NewTicker(c.gossip.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case op := <-c.ops:
+			op()
+		case <-tick:
+			c.gossipRound()
+		case msg := <-c.in:
+			if err := c.handle(msg); err != nil {
+				// remote peer has sent us a message we cannot accept: it's either
+				// faulty or malicious, either way it's penalized for it
+				c.misbehaved(msg.from, err)
+			}
+		}
+	}
+}
+
+func (c *PeerController) handle(msg message) error {
+	if len(msg.data) == 0 {
+		return MalformedMessageError
+	}
+	r := bufio.NewReader(bytes.NewReader(msg.data[1:]))
+	switch msg.data[0] {
+	case MsgAnnounce:
+		heads, err := c.limits.ReadIDs(r)
+		if err != nil {
+			return err
+		}
+		c.observe(msg.from, heads)
+		ids := c.peer.NotFound(heads)
+		if len(ids) > 0 {
+			c.request(msg.from, ids)
+		}
+	case MsgSync:
+		heads, err := c.limits.ReadIDs(r)
+		if err != nil {
+			return err
+		}
+		filter, err := c.limits.ReadBitmap(r)
+		if err != nil {
+			return err
+		}
+		known := c.observe(msg.from, heads)
+		// reply even if there's nothing to send: remote uses our reply to detect heads,
+		// which it didn't receive because of Bloom filter false positives
+		r, ok := c.remote(msg.from)
+		if !ok {
+			return nil
+		}
+		c.serve(r, r.interest.serve(c.peer.BloomMissing(heads, filter)))
+		// if we're missing some of the remote heads, ask remote to do the same for us. Once
+		// the exchange is complete, we'll know all remote heads and won't reply with sync again
+		if len(c.peer.NotFound(heads)) > 0 {
+			c.sync(r, known)
+		}
+	case MsgRequest:
+		ids, err := c.limits.ReadIDs(r)
+		if err != nil {
+			return err
+		}
+		r, ok := c.remote(msg.from)
+		if !ok {
+			return nil
+		}
+		if records := r.interest.serve(c.peer.Request(ids)); len(records) > 0 {
+			c.serve(r, records)
+		}
+	case MsgInterest:
+		in, err := c.limits.readInterest(r)
+		if err != nil {
+			return err
+		}
+		if r, ok := c.remote(msg.from); ok {
+			r.interest = in
+		}
+	case MsgRecords, MsgPartial, MsgMore:
+		var records []*Record
+		var err error
+		more := msg.data[0] == MsgMore
+		if msg.data[0] != MsgRecords {
+			records, err = c.limits.readPartial(r)
+		} else {
+			records, err = c.limits.ReadRecords(r)
+		}
+		if err != nil {
+			return err
+		}
+		if r, ok := c.remote(msg.from); ok {
+			if n := r.unsolicited(records, more); n > 0 {
+				c.penalize(msg.from, offenseUnsolicited, n)
+			}
+		}
+		if err = c.peer.Integrate(records); err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			c.succeed(msg.from)
+		}
+		if more {
+			return nil // the rest of the reply will bring missing records
+		}
+		// we only ask for missing dependencies and remote heads after receiving records,
+		// which remote peer will reply to only if it has any of them, so this exchange
+		// always terminates
+		ids := append(c.peer.MissingDeps(), c.peer.NotFound(c.remoteHeads(msg.from))...)
+		if len(ids) > 0 {
+			c.request(msg.from, ids)
+		}
+		if r, ok := c.remote(msg.from); ok && len(records) > 0 {
+			// acknowledge received records, so that remote can track their causal stability
+			r.send(encodeIDs(MsgAnnounce, c.peer.announceTo(r.interest.collections)))
+		}
+	default:
+		return MalformedMessageError
+	}
+	return nil
+}
+
+// observe updates the heads known to be shared with a remote, given the heads it has announced.
+// Returns the updated heads.
+func (c *PeerController) observe(name string, heads []ID) []ID {
+	c.peer.Acknowledge(name, heads)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.out[name]
+	if !ok {
+		return nil
+	}
+	var known []ID
+	for _, h := range heads {
+		if c.peer.store.Contains(h) {
+			known = append(known, h)
+		}
+	}
+	if len(known) > 0 {
+		r.known = known
+	}
+	c.healthOf(name).synced = c.now()
+	r.heads = heads
+	return r.known
+}
+
+// remoteHeads returns the latest heads announced by a remote with a given name.
+func (c *PeerController) remoteHeads(name string) []ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.out[name]; ok {
+		return r.heads
+	}
+	return nil
+}
+
+func encodeSync(p *Peer, heads []ID, since []ID) []byte {
+	_, filter := p.BloomAnnounce(since)
+	return encodeMsg(MsgSync, func(w io.Writer) error {
+		if err := WriteIDs(heads, w); err != nil {
+			return err
+		}
+		return WriteBitmap(filter, w)
+	})
+}
+
+func encodeIDs(msgType byte, ids []ID) []byte {
+	return encodeMsg(msgType, func(w io.Writer) error {
+		return WriteIDs(ids, w)
+	})
+}
+
+func encodeRecords(msgType byte, rs []*Record) []byte {
+	return encodeMsg(msgType, func(w io.Writer) error {
+		return WriteRecords(rs, w)
+	})
+}
+
+func encodeMsg(msgType byte, write func(w io.Writer) error) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(msgType)
+	_ = write(&buf) // writes to bytes.Buffer never fail
+	return buf.Bytes()
+}
@@ -0,0 +1,154 @@
+package bec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func newTestPeer(t *testing.T) *Peer {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+	return NewPeer(pub, priv, NewMemStore())
+}
+
+// link connects two controllers with each other using a pair of channels.
+func link(t *testing.T, a *PeerController, aName string, b *PeerController, bName string) {
+	ab := make(chan []byte)
+	ba := make(chan []byte)
+	if err := a.Connect(bName, ba, ab); err != nil {
+		t.Fatalf("failed to connect %s->%s: %s", aName, bName, err.Error())
+	}
+	if err := b.Connect(aName, ab, ba); err != nil {
+		t.Fatalf("failed to connect %s->%s: %s", bName, aName, err.Error())
+	}
+}
+
+func heads(t *testing.T, c *PeerController) []ID {
+	var res []ID
+	err := c.Do(func(p *Peer) error {
+		res = append(res, p.Heads()...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read heads: %s", err.Error())
+	}
+	return res
+}
+
+func sameIDs(a []ID, b []ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if bytes.Equal(x, y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func awaitConvergence(t *testing.T, a *PeerController, b *PeerController) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sameIDs(heads(t, a), heads(t, b)) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("peers didn't converge in time")
+}
+
+func TestControllerConverge(t *testing.T) {
+	p1 := newTestPeer(t)
+	p2 := newTestPeer(t)
+
+	records := testRecords(p1.pub, p1.priv)
+	if err := p1.Integrate(records); err != nil {
+		t.Fatalf("P1 failed to integrate init records: %s", err.Error())
+	}
+	if err := p2.Integrate(records[:2]); err != nil {
+		t.Fatalf("P2 failed to integrate init records: %s", err.Error())
+	}
+	if _, err := p2.Commit([]byte("G")); err != nil {
+		t.Fatalf("P2 failed to commit 'G': %s", err.Error())
+	}
+
+	c1 := NewController(p1)
+	c2 := NewController(p2)
+	go c1.Process()
+	go c2.Process()
+
+	link(t, c1, "p1", c2, "p2")
+	awaitConvergence(t, c1, c2)
+
+	// new commits are propagated to connected remotes
+	if _, err := c1.Commit([]byte("H")); err != nil {
+		t.Fatalf("P1 failed to commit 'H': %s", err.Error())
+	}
+	awaitConvergence(t, c1, c2)
+
+	c1.Close()
+	c2.Close()
+	compareStores(p1.store, p2.store, t)
+}
+
+func TestControllerBatches(t *testing.T) {
+	p1 := newTestPeer(t)
+	p2 := newTestPeer(t)
+	limits := Limits{MaxRecords: 4}
+	for i := 0; i < 3*limits.MaxRecords+1; i++ {
+		if _, err := p1.Commit([]byte{byte(i)}); err != nil {
+			t.Fatalf("P1 failed to commit: %s", err.Error())
+		}
+	}
+
+	// replies exceeding remote limits would get it disconnected for sending malformed messages
+	c1 := NewController(p1, WithLimits(limits))
+	c2 := NewController(p2, WithLimits(limits))
+	go c1.Process()
+	go c2.Process()
+	defer c1.Close()
+	defer c2.Close()
+
+	link(t, c1, "p1", c2, "p2")
+	awaitConvergence(t, c1, c2)
+	if rep, _ := c2.Reputation("p1"); rep.Score != 0 {
+		t.Fatalf("expected batched reply not to be penalized: %+v", rep)
+	}
+}
+
+func TestControllerDisconnectOnMalformed(t *testing.T) {
+	c := NewController(newTestPeer(t))
+	go c.Process()
+	defer c.Close()
+
+	in := make(chan []byte)
+	out := make(chan []byte, 1)
+	if err := c.Connect("remote", in, out); err != nil {
+		t.Fatalf("failed to connect: %s", err.Error())
+	}
+	<-out // initial announcement
+	in <- []byte{0xff}
+
+	// controller closes the outgoing channel once remote is disconnected
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatalf("expected remote to be disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("remote has not been disconnected in time")
+	}
+}
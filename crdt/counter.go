@@ -0,0 +1,53 @@
+package crdt
+
+import (
+	"encoding/binary"
+
+	"bec"
+)
+
+// Counter is a counter, which can be both incremented and decremented (PN-counter).
+type Counter struct {
+	name string
+	inc  uint64 // sum of increments
+	dec  uint64 // sum of decrements
+}
+
+var _ Object = (*Counter)(nil)
+
+// NewCounter returns a Counter with a given name, starting at 0.
+func NewCounter(name string) *Counter {
+	return &Counter{name: name}
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+// Add returns Record data of an operation, which adds a given delta to the Counter.
+func (c *Counter) Add(delta int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], delta)
+	return encode(kindCounter, opAdd, c.name, buf[:n])
+}
+
+// Value returns the current value of the Counter. It wraps around on overflow.
+func (c *Counter) Value() int64 {
+	return int64(c.inc - c.dec)
+}
+
+func (c *Counter) Apply(r *bec.Record) {
+	o, ok := decode(r, kindCounter, c.name)
+	if !ok || o.code != opAdd || len(o.fields) != 1 {
+		return
+	}
+	delta, n := binary.Varint(o.fields[0])
+	if n != len(o.fields[0]) {
+		return
+	}
+	if delta >= 0 {
+		c.inc += uint64(delta)
+	} else {
+		c.dec += uint64(-delta)
+	}
+}
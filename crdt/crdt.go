@@ -0,0 +1,120 @@
+// Package crdt implements conflict-free replicated data types on top of the causal log of records.
+//
+// Every object is identified by its name. Its operations are encoded into Record data, which is
+// committed with bec.Peer.Commit, and its state is materialized by applying records in causal
+// order, either by replaying a whole Store or by applying records as they get committed:
+//
+//	set := crdt.NewSet("tags")
+//	crdt.Replay(store, set)
+//	r, err := peer.Commit(set.Add([]byte("urgent")))
+//	if err == nil {
+//		set.Apply(r)
+//	}
+//
+// Records carrying malformed operations, which may be created by Byzantine authors, are ignored,
+// so that all replicas with the same records end up with the same state.
+package crdt
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"bec"
+)
+
+// Object is a CRDT, which state is materialized from operations carried by records.
+type Object interface {
+	// Name returns the name identifying operations of the object.
+	Name() string
+	// Apply updates the object state with an operation carried by a given Record. Records must be
+	// applied after all of their dependencies. Records carrying no operations of the object are ignored.
+	Apply(r *bec.Record)
+}
+
+// Replay applies all records of a Store to given objects in a deterministic causal order. Pruned
+// records are not visited, so states of objects should be restored from snapshots before replaying
+// records committed after them.
+func Replay(s bec.Store, objs ...Object) {
+	it := bec.IterateForward(s, nil)
+	for r := it.Next(); r != nil; r = it.Next() {
+		for _, obj := range objs {
+			obj.Apply(r)
+		}
+	}
+}
+
+// opPrefix marks records carrying CRDT operations instead of user data.
+const opPrefix = "\x00bec/crdt\x00"
+
+// kind is a type of the object an operation belongs to, so that objects of different types
+// sharing the same name don't interpret operations of each other.
+type kind byte
+
+const (
+	kindRegister kind = iota + 1
+	kindSet
+	kindCounter
+	kindMap
+	kindSequence
+)
+
+const (
+	opSet    byte = 1 // sets a register value or a map entry
+	opAdd    byte = 2 // adds an element to a set or a delta to a counter
+	opRemove byte = 3 // removes observed elements of a set or entries of a map, or elements of a sequence
+	opInsert byte = 4 // inserts elements into a sequence
+)
+
+// op is an operation decoded from a Record.
+type op struct {
+	kind   kind
+	code   byte
+	name   string
+	fields [][]byte // operation arguments
+}
+
+// encode returns Record data carrying a given operation.
+func encode(k kind, code byte, name string, fields ...[]byte) []byte {
+	var buf bytes.Buffer
+	var inlined [binary.MaxVarintLen64]byte // inline buffer for variable length integers
+	writeField := func(f []byte) {
+		n := binary.PutUvarint(inlined[:], uint64(len(f)))
+		buf.Write(inlined[:n])
+		buf.Write(f)
+	}
+	buf.WriteString(opPrefix)
+	buf.WriteByte(byte(k))
+	buf.WriteByte(code)
+	writeField([]byte(name))
+	for _, f := range fields {
+		writeField(f)
+	}
+	return buf.Bytes()
+}
+
+// decode returns an operation of a given object carried by a Record, or false if there's none.
+func decode(r *bec.Record, k kind, name string) (*op, bool) {
+	data := r.Data()
+	if !bytes.HasPrefix(data, []byte(opPrefix)) {
+		return nil, false
+	}
+	data = data[len(opPrefix):]
+	if len(data) < 2 || kind(data[0]) != k {
+		return nil, false
+	}
+	res := &op{kind: kind(data[0]), code: data[1]}
+	data = data[2:]
+	for len(data) > 0 {
+		n, read := binary.Uvarint(data)
+		if read <= 0 || n > uint64(len(data)-read) {
+			return nil, false
+		}
+		res.fields = append(res.fields, data[read:read+int(n)])
+		data = data[read+int(n):]
+	}
+	if len(res.fields) == 0 || string(res.fields[0]) != name {
+		return nil, false
+	}
+	res.name, res.fields = name, res.fields[1:]
+	return res, true
+}
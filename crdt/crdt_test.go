@@ -0,0 +1,164 @@
+package crdt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"bec"
+)
+
+type replica struct {
+	peer  *bec.Peer
+	store *bec.MemStore
+}
+
+func newReplica(t *testing.T) *replica {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s := bec.NewMemStore()
+	return &replica{peer: bec.NewPeer(pub, priv, s), store: s}
+}
+
+func (r *replica) commit(t *testing.T, obj Object, data []byte) {
+	rec, err := r.peer.Commit(data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	obj.Apply(rec)
+}
+
+// merge exchanges all records between replicas.
+func merge(t *testing.T, a *replica, b *replica) {
+	if err := a.peer.Integrate(b.store.Missing(nil)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := b.peer.Integrate(a.store.Missing(nil)); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	ra, rb := NewRegister("title"), NewRegister("title")
+	a.commit(t, ra, ra.Set([]byte("first")))
+	merge(t, a, b)
+	Replay(b.store, rb)
+	if !bytes.Equal(rb.Value(), []byte("first")) {
+		t.Fatalf("expected value to be replayed, got %q", rb.Value())
+	}
+	// write of b has observed the one of a, so it wins regardless of ids
+	b.commit(t, rb, rb.Set([]byte("second")))
+	a.commit(t, ra, ra.Set([]byte("concurrent")))
+	merge(t, a, b)
+
+	ra, rb = NewRegister("title"), NewRegister("title")
+	Replay(a.store, ra)
+	Replay(b.store, rb)
+	if !bytes.Equal(ra.Value(), rb.Value()) {
+		t.Fatalf("replicas diverged: %q and %q", ra.Value(), rb.Value())
+	}
+}
+
+func TestSet(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	sa, sb := NewSet("tags"), NewSet("tags")
+	a.commit(t, sa, sa.Add([]byte("x")))
+	a.commit(t, sa, sa.Add([]byte("y")))
+	merge(t, a, b)
+	Replay(b.store, sb)
+	// concurrent removal and addition of the same element: addition wins
+	a.commit(t, sa, sa.Remove([]byte("x")))
+	b.commit(t, sb, sb.Add([]byte("x")))
+	b.commit(t, sb, sb.Remove([]byte("y")))
+	if sa.Contains([]byte("x")) || sb.Contains([]byte("y")) {
+		t.Fatalf("expected elements to be removed locally")
+	}
+	merge(t, a, b)
+
+	for _, r := range []*replica{a, b} {
+		s := NewSet("tags")
+		Replay(r.store, s)
+		if res := s.Elements(); len(res) != 1 || !bytes.Equal(res[0], []byte("x")) {
+			t.Fatalf("expected only concurrently added element to stay, got %q", res)
+		}
+	}
+}
+
+func TestSetRemovalBeforeAddition(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	s := NewSet("tags")
+	add, err := a.peer.Commit(s.Add([]byte("x")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// Byzantine author removes an addition it has not observed
+	rm, err := b.peer.Commit(encode(kindSet, opRemove, "tags", []byte("x"), add.ID()))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s.Apply(rm)
+	s.Apply(add)
+	if s.Contains([]byte("x")) {
+		t.Fatalf("expected removal to commute with addition")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	ca, cb := NewCounter("votes"), NewCounter("votes")
+	a.commit(t, ca, ca.Add(5))
+	b.commit(t, cb, cb.Add(-2))
+	b.commit(t, cb, cb.Add(1))
+	merge(t, a, b)
+
+	for _, r := range []*replica{a, b} {
+		c := NewCounter("votes")
+		Replay(r.store, c)
+		if c.Value() != 4 {
+			t.Fatalf("expected counter value 4, got %d", c.Value())
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	ma, mb := NewMap("profile"), NewMap("profile")
+	a.commit(t, ma, ma.Put("name", []byte("alice")))
+	a.commit(t, ma, ma.Put("city", []byte("paris")))
+	merge(t, a, b)
+	Replay(b.store, mb)
+	b.commit(t, mb, mb.Put("name", []byte("bob")))
+	b.commit(t, mb, mb.Delete("city"))
+	a.commit(t, ma, ma.Delete("name"))
+	merge(t, a, b)
+
+	for _, r := range []*replica{a, b} {
+		m := NewMap("profile")
+		Replay(r.store, m)
+		if keys := m.Keys(); len(keys) != 1 || keys[0] != "name" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		if v, ok := m.Get("name"); !ok || !bytes.Equal(v, []byte("bob")) {
+			t.Fatalf("expected concurrent write to survive deletion, got %q", v)
+		}
+	}
+}
+
+func TestObjectsIgnoreOtherOperations(t *testing.T) {
+	a := newReplica(t)
+	reg, set := NewRegister("x"), NewSet("x")
+	a.commit(t, reg, []byte("plain data"))
+	a.commit(t, reg, set.Add([]byte("elem")))
+	a.commit(t, reg, NewRegister("y").Set([]byte("other")))
+	if reg.Value() != nil {
+		t.Fatalf("expected register to ignore other operations, got %q", reg.Value())
+	}
+	Replay(a.store, set)
+	if !set.Contains([]byte("elem")) {
+		t.Fatalf("expected set to apply its operations")
+	}
+}
@@ -0,0 +1,98 @@
+package crdt
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"bec"
+)
+
+// Map is a map of byte strings by string keys. Values of keys are last-writer-wins registers,
+// while deletion of a key removes only the writes it has observed, so that a key written
+// concurrently to its deletion stays in the Map.
+type Map struct {
+	name    string
+	tags    map[string]map[string]struct{} // tags of writes, which have not been removed yet, by keys
+	writes  map[string]*entry              // writes by their tags
+	removed map[string]struct{}            // removed tags, so that deletions of writes not seen yet are not lost
+}
+
+// entry is a value written to a Map key.
+type entry struct {
+	value  []byte
+	record *bec.Record
+}
+
+var _ Object = (*Map)(nil)
+
+// NewMap returns an empty Map with a given name.
+func NewMap(name string) *Map {
+	return &Map{
+		name:    name,
+		tags:    make(map[string]map[string]struct{}),
+		writes:  make(map[string]*entry),
+		removed: make(map[string]struct{}),
+	}
+}
+
+func (m *Map) Name() string {
+	return m.name
+}
+
+// Put returns Record data of an operation, which writes a value of a given key.
+func (m *Map) Put(key string, value []byte) []byte {
+	return encode(kindMap, opSet, m.name, []byte(key), value)
+}
+
+// Delete returns Record data of an operation, which deletes a given key, as it's currently observed.
+func (m *Map) Delete(key string) []byte {
+	return encode(kindMap, opRemove, m.name, observed([]byte(key), m.tags[key])...)
+}
+
+// Get returns the current value of a given key, or false if the key is not in the Map.
+func (m *Map) Get(key string) ([]byte, bool) {
+	var last *entry
+	for tag := range m.tags[key] {
+		if e := m.writes[tag]; last == nil || last.record.Before(e.record) {
+			last = e
+		}
+	}
+	if last == nil {
+		return nil, false
+	}
+	return last.value, true
+}
+
+// Keys returns all keys of the Map in lexicographical order.
+func (m *Map) Keys() []string {
+	res := make([]string, 0, len(m.tags))
+	for key := range m.tags {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (m *Map) Apply(r *bec.Record) {
+	o, ok := decode(r, kindMap, m.name)
+	if !ok || len(o.fields) == 0 {
+		return
+	}
+	key := string(o.fields[0])
+	switch o.code {
+	case opSet:
+		if len(o.fields) != 2 {
+			return
+		}
+		tag := hex.EncodeToString(r.ID())
+		if _, ok := m.removed[tag]; !ok {
+			m.writes[tag] = &entry{value: o.fields[1], record: r}
+			add(m.tags, m.removed, key, tag)
+		}
+	case opRemove:
+		remove(m.tags, m.removed, key, o.fields[1:])
+		for _, id := range o.fields[1:] {
+			delete(m.writes, hex.EncodeToString(id))
+		}
+	}
+}
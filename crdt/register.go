@@ -0,0 +1,44 @@
+package crdt
+
+import "bec"
+
+// Register is a last-writer-wins register. Concurrent writes are resolved by timestamps of their
+// records, falling back to their ids, see bec.Record.Before. Records created by bec.Peer.Commit are
+// timestamped, so that a write always wins over the writes it has observed.
+type Register struct {
+	name  string
+	value []byte
+	last  *bec.Record // record, which has written the current value
+}
+
+var _ Object = (*Register)(nil)
+
+// NewRegister returns an empty Register with a given name.
+func NewRegister(name string) *Register {
+	return &Register{name: name}
+}
+
+func (reg *Register) Name() string {
+	return reg.name
+}
+
+// Set returns Record data of an operation, which writes a given value.
+func (reg *Register) Set(value []byte) []byte {
+	return encode(kindRegister, opSet, reg.name, value)
+}
+
+// Value returns the current value of the Register, or nil if it has never been written.
+func (reg *Register) Value() []byte {
+	return reg.value
+}
+
+func (reg *Register) Apply(r *bec.Record) {
+	o, ok := decode(r, kindRegister, reg.name)
+	if !ok || o.code != opSet || len(o.fields) != 1 {
+		return
+	}
+	if reg.last == nil || reg.last.Before(r) {
+		reg.value = o.fields[0]
+		reg.last = r
+	}
+}
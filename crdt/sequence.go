@@ -0,0 +1,266 @@
+package crdt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+
+	"bec"
+)
+
+// ElementID identifies an element of a Sequence by the record, which has inserted it, and its
+// position among elements inserted by that record.
+type ElementID struct {
+	Record bec.ID
+	Index  int
+}
+
+// Equal checks if two element identifiers are the same.
+func (id ElementID) Equal(o ElementID) bool {
+	return id.Index == o.Index && bytes.Equal(id.Record, o.Record)
+}
+
+// key returns a binary representation of the ElementID, used both as a map key and in operations.
+func (id ElementID) key() string {
+	var inlined [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(inlined[:], uint64(id.Index))
+	return string(id.Record) + string(inlined[:n])
+}
+
+// parseElementID parses a binary representation of an ElementID returned by ElementID.key.
+func parseElementID(b []byte) (ElementID, bool) {
+	if len(b) <= sha256.Size {
+		return ElementID{}, false
+	}
+	idx, n := binary.Uvarint(b[sha256.Size:])
+	if n <= 0 || sha256.Size+n != len(b) || idx > maxIndex {
+		return ElementID{}, false
+	}
+	return ElementID{Record: b[:sha256.Size], Index: int(idx)}, true
+}
+
+// maxIndex is the maximum number of elements inserted by a single record.
+const maxIndex = 1<<31 - 1
+
+// element is a node of the RGA tree. Every inserted element is a child of the element it has been
+// inserted after, so that the document is a pre-order traversal of the tree.
+type element struct {
+	id       ElementID
+	record   *bec.Record // record, which has inserted the element, nil for the root
+	value    []byte
+	deleted  bool
+	children []*element // ordered from the most recently inserted one
+}
+
+// newer checks if an element has been inserted after its sibling, so that it goes first.
+func (e *element) newer(o *element) bool {
+	if e.record != o.record {
+		return o.record.Before(e.record)
+	}
+	return e.id.Index > o.id.Index
+}
+
+// Sequence is a replicated growable array (RGA): an ordered list of values, such as characters of
+// a text document. Every element is inserted after an existing one and keeps its position relative
+// to it, while concurrent insertions after the same element are ordered from the most recent one,
+// using the order of their records (see bec.Record.Before). Deleted elements are kept as
+// tombstones, so that concurrent insertions can still refer to them.
+//
+// The materialized document doesn't depend on the order records are applied in, so it can be both
+// replayed from a Store and updated incrementally with records delivered by bec.Peer.Subscribe.
+type Sequence struct {
+	name     string
+	root     *element
+	elements map[string]*element   // inserted elements by their ids
+	deleted  map[string]struct{}   // ids of deleted elements, which have not been inserted yet
+	pending  map[string][]*element // inserted elements, which are waiting for the element they have been inserted after
+	visible  []*element            // cached elements, which have not been deleted, in their order
+	dirty    bool                  // visible has to be recomputed
+}
+
+var _ Object = (*Sequence)(nil)
+
+// NewSequence returns an empty Sequence with a given name.
+func NewSequence(name string) *Sequence {
+	return &Sequence{
+		name:     name,
+		root:     &element{},
+		elements: make(map[string]*element),
+		deleted:  make(map[string]struct{}),
+		pending:  make(map[string][]*element),
+	}
+}
+
+func (s *Sequence) Name() string {
+	return s.name
+}
+
+// Insert returns Record data of an operation, which inserts given values at a given position,
+// which must be within [0, Len()].
+func (s *Sequence) Insert(pos int, values ...[]byte) []byte {
+	var anchor []byte // insertion at the beginning refers to the root
+	if pos > 0 {
+		anchor = []byte(s.elems()[pos-1].id.key())
+	}
+	return encode(kindSequence, opInsert, s.name, append([][]byte{anchor}, values...)...)
+}
+
+// InsertText returns Record data of an operation, which inserts every rune of a given text as
+// a separate element at a given position.
+func (s *Sequence) InsertText(pos int, text string) []byte {
+	values := make([][]byte, 0, len(text))
+	for _, r := range text {
+		values = append(values, []byte(string(r)))
+	}
+	return s.Insert(pos, values...)
+}
+
+// Delete returns Record data of an operation, which deletes n elements starting at a given
+// position. The range must be within [0, Len()].
+func (s *Sequence) Delete(pos int, n int) []byte {
+	var fields [][]byte
+	for _, e := range s.elems()[pos : pos+n] {
+		fields = append(fields, []byte(e.id.key()))
+	}
+	return encode(kindSequence, opRemove, s.name, fields...)
+}
+
+// Len returns the number of elements in the Sequence.
+func (s *Sequence) Len() int {
+	return len(s.elems())
+}
+
+// ID returns the identifier of an element at a given position.
+func (s *Sequence) ID(pos int) ElementID {
+	return s.elems()[pos].id
+}
+
+// Values returns all elements of the Sequence in their order.
+func (s *Sequence) Values() [][]byte {
+	elems := s.elems()
+	res := make([][]byte, len(elems))
+	for i, e := range elems {
+		res[i] = e.value
+	}
+	return res
+}
+
+// Text returns all elements of the Sequence concatenated.
+func (s *Sequence) Text() string {
+	var sb strings.Builder
+	for _, e := range s.elems() {
+		sb.Write(e.value)
+	}
+	return sb.String()
+}
+
+func (s *Sequence) Apply(r *bec.Record) {
+	o, ok := decode(r, kindSequence, s.name)
+	if !ok {
+		return
+	}
+	switch o.code {
+	case opInsert:
+		s.insert(r, o.fields)
+	case opRemove:
+		s.remove(o.fields)
+	}
+}
+
+// insert inserts elements carried by a given record. Fields start with an id of the element they
+// are inserted after.
+func (s *Sequence) insert(r *bec.Record, fields [][]byte) {
+	if len(fields) < 2 || len(fields)-1 > maxIndex {
+		return
+	}
+	var anchor string
+	if len(fields[0]) > 0 {
+		id, ok := parseElementID(fields[0])
+		if !ok {
+			return
+		}
+		anchor = id.key()
+	}
+	if _, ok := s.elements[ElementID{Record: r.ID()}.key()]; ok {
+		return // already applied
+	}
+	var prev *element
+	for i, v := range fields[1:] {
+		e := &element{id: ElementID{Record: r.ID(), Index: i}, record: r, value: v}
+		key := e.id.key()
+		s.elements[key] = e
+		if _, ok := s.deleted[key]; ok {
+			e.deleted = true
+			delete(s.deleted, key)
+		}
+		if prev != nil {
+			s.attach(prev, e)
+		} else if anchor == "" {
+			s.attach(s.root, e)
+		} else if parent, ok := s.elements[anchor]; ok {
+			s.attach(parent, e)
+		} else {
+			// honest authors insert only after elements they have seen, so it must be a Byzantine one
+			s.pending[anchor] = append(s.pending[anchor], e)
+		}
+		prev = e
+	}
+	s.dirty = true
+}
+
+// attach makes an element a child of a given parent, followed by all pending children of its own.
+func (s *Sequence) attach(parent *element, e *element) {
+	i := 0
+	for i < len(parent.children) && parent.children[i].newer(e) {
+		i++
+	}
+	parent.children = append(parent.children, nil)
+	copy(parent.children[i+1:], parent.children[i:])
+	parent.children[i] = e
+
+	key := e.id.key()
+	pending := s.pending[key]
+	delete(s.pending, key)
+	for _, c := range pending {
+		s.attach(e, c)
+	}
+}
+
+// remove deletes elements with given ids.
+func (s *Sequence) remove(fields [][]byte) {
+	for _, f := range fields {
+		id, ok := parseElementID(f)
+		if !ok {
+			continue
+		}
+		if e, ok := s.elements[id.key()]; ok {
+			e.deleted = true
+		} else {
+			s.deleted[id.key()] = struct{}{}
+		}
+	}
+	s.dirty = true
+}
+
+// elems returns elements, which have not been deleted, in their order.
+func (s *Sequence) elems() []*element {
+	if !s.dirty {
+		return s.visible
+	}
+	s.visible = s.visible[:0]
+	// pre-order traversal without recursion, since runs of inserted elements form long chains
+	stack := []*element{s.root}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e != s.root && !e.deleted {
+			s.visible = append(s.visible, e)
+		}
+		for i := len(e.children) - 1; i >= 0; i-- {
+			stack = append(stack, e.children[i])
+		}
+	}
+	s.dirty = false
+	return s.visible
+}
@@ -0,0 +1,109 @@
+package crdt
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSequence(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	sa, sb := NewSequence("doc"), NewSequence("doc")
+	a.commit(t, sa, sa.InsertText(0, "held"))
+	a.commit(t, sa, sa.InsertText(2, "llo wor"))
+	if text := sa.Text(); text != "hello world" {
+		t.Fatalf("unexpected text: %q", text)
+	}
+	merge(t, a, b)
+	Replay(b.store, sb)
+	if text := sb.Text(); text != "hello world" {
+		t.Fatalf("unexpected replayed text: %q", text)
+	}
+
+	// concurrent insertions at the same position are not interleaved
+	a.commit(t, sa, sa.InsertText(0, "abc "))
+	b.commit(t, sb, sb.InsertText(0, "xyz "))
+	b.commit(t, sb, sb.Delete(sb.Len()-5, 5))
+	merge(t, a, b)
+
+	ra, rb := NewSequence("doc"), NewSequence("doc")
+	Replay(a.store, ra)
+	Replay(b.store, rb)
+	if ra.Text() != rb.Text() {
+		t.Fatalf("replicas diverged: %q and %q", ra.Text(), rb.Text())
+	}
+	if text := ra.Text(); text != "abc xyz hello " && text != "xyz abc hello " {
+		t.Fatalf("unexpected merged text: %q", text)
+	}
+	// the trailing space is the 4th element inserted by "llo wor"
+	if id := ra.ID(ra.Len() - 1); !id.Equal(rb.ID(rb.Len()-1)) || id.Index != 3 {
+		t.Fatalf("expected element to be identified by its position in the record, got %d", id.Index)
+	}
+}
+
+func TestSequenceApplyOrder(t *testing.T) {
+	peers := []*replica{newReplica(t), newReplica(t), newReplica(t)}
+	seqs := []*Sequence{NewSequence("doc"), NewSequence("doc"), NewSequence("doc")}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for round := 0; round < 10; round++ {
+		for i, p := range peers {
+			s := seqs[i]
+			if s.Len() > 0 && rnd.Intn(3) == 0 {
+				pos := rnd.Intn(s.Len())
+				p.commit(t, s, s.Delete(pos, 1+rnd.Intn(s.Len()-pos)))
+			} else {
+				p.commit(t, s, s.InsertText(rnd.Intn(s.Len()+1), "ab"))
+			}
+		}
+		// records are applied in their commit order, which differs between replicas
+		a, b := rnd.Intn(len(peers)), rnd.Intn(len(peers))
+		if a != b {
+			before := peers[a].store.Len()
+			merge(t, peers[a], peers[b])
+			for _, r := range peers[a].store.Since(before, peers[a].store.Len()) {
+				seqs[a].Apply(r)
+			}
+			Replay(peers[b].store, seqs[b])
+		}
+	}
+	// the first replica collects all records, then spreads them to the others
+	for round := 0; round < 2; round++ {
+		for i := 1; i < len(peers); i++ {
+			merge(t, peers[0], peers[i])
+		}
+	}
+	expected := NewSequence("doc")
+	Replay(peers[0].store, expected)
+	for i, p := range peers {
+		for _, r := range p.store.Missing(nil) {
+			seqs[i].Apply(r)
+		}
+		if seqs[i].Text() != expected.Text() {
+			t.Fatalf("replica %d diverged: %q instead of %q", i, seqs[i].Text(), expected.Text())
+		}
+	}
+}
+
+func TestSequenceSubscribe(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	s := NewSequence("doc")
+	sub := b.peer.Subscribe(0)
+	defer sub.Close()
+
+	edit := NewSequence("doc")
+	a.commit(t, edit, edit.InsertText(0, "hello"))
+	a.commit(t, edit, edit.Delete(0, 1))
+	a.commit(t, edit, edit.InsertText(0, "j"))
+	merge(t, a, b)
+	for i := 0; i < 3; i++ {
+		select {
+		case u := <-sub.C:
+			s.Apply(u.Record)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for records")
+		}
+	}
+	if text := s.Text(); text != "jello" {
+		t.Fatalf("unexpected text: %q", text)
+	}
+}
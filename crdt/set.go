@@ -0,0 +1,112 @@
+package crdt
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"bec"
+)
+
+// Set is an observed-remove set of byte strings. Every addition is tagged with the id of its record
+// and removal removes only the tags it has observed, so that an element added concurrently to its
+// removal stays in the set.
+type Set struct {
+	name    string
+	tags    map[string]map[string]struct{} // tags of additions, which have not been removed yet, by elements
+	removed map[string]struct{}            // removed tags, so that removals of tags not seen yet are not lost
+}
+
+var _ Object = (*Set)(nil)
+
+// NewSet returns an empty Set with a given name.
+func NewSet(name string) *Set {
+	return &Set{
+		name:    name,
+		tags:    make(map[string]map[string]struct{}),
+		removed: make(map[string]struct{}),
+	}
+}
+
+func (s *Set) Name() string {
+	return s.name
+}
+
+// Add returns Record data of an operation, which adds a given element.
+func (s *Set) Add(elem []byte) []byte {
+	return encode(kindSet, opAdd, s.name, elem)
+}
+
+// Remove returns Record data of an operation, which removes a given element, as it's currently observed.
+func (s *Set) Remove(elem []byte) []byte {
+	return encode(kindSet, opRemove, s.name, observed(elem, s.tags[string(elem)])...)
+}
+
+// Contains checks if a given element is in the Set.
+func (s *Set) Contains(elem []byte) bool {
+	return len(s.tags[string(elem)]) > 0
+}
+
+// Elements returns all elements of the Set in lexicographical order.
+func (s *Set) Elements() [][]byte {
+	res := make([][]byte, 0, len(s.tags))
+	for elem := range s.tags {
+		res = append(res, []byte(elem))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return string(res[i]) < string(res[j])
+	})
+	return res
+}
+
+func (s *Set) Apply(r *bec.Record) {
+	o, ok := decode(r, kindSet, s.name)
+	if !ok || len(o.fields) == 0 {
+		return
+	}
+	elem := string(o.fields[0])
+	switch o.code {
+	case opAdd:
+		if len(o.fields) == 1 {
+			add(s.tags, s.removed, elem, hex.EncodeToString(r.ID()))
+		}
+	case opRemove:
+		remove(s.tags, s.removed, elem, o.fields[1:])
+	}
+}
+
+// observed returns fields of a removal operation of an element with given tags.
+func observed(elem []byte, tags map[string]struct{}) [][]byte {
+	fields := [][]byte{elem}
+	for tag := range tags {
+		id, _ := hex.DecodeString(tag)
+		fields = append(fields, id)
+	}
+	return fields
+}
+
+// add tags an element, unless the tag has already been removed.
+func add(tags map[string]map[string]struct{}, removed map[string]struct{}, elem string, tag string) {
+	if _, ok := removed[tag]; ok {
+		return
+	}
+	t, ok := tags[elem]
+	if !ok {
+		t = make(map[string]struct{})
+		tags[elem] = t
+	}
+	t[tag] = struct{}{}
+}
+
+// remove removes given tags of an element. Tags may not have been seen yet, if removal was created
+// by a Byzantine author, so they're remembered to be removed once they're added.
+func remove(tags map[string]map[string]struct{}, removed map[string]struct{}, elem string, ids [][]byte) {
+	t := tags[elem]
+	for _, id := range ids {
+		tag := hex.EncodeToString(id)
+		removed[tag] = struct{}{}
+		delete(t, tag)
+	}
+	if t != nil && len(t) == 0 {
+		delete(tags, elem)
+	}
+}
@@ -0,0 +1,349 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+var (
+	// KeyNotFoundError happens when encrypting records of a collection, which has no key shared with
+	// the current peer, or decrypting records encrypted by a key, which hasn't been shared with it.
+	KeyNotFoundError = fmt.Errorf("collection key not found")
+	// DecryptionError happens when an encrypted payload doesn't match the key it claims to be encrypted by.
+	DecryptionError = fmt.Errorf("record payload cannot be decrypted")
+	// InvalidKeyError happens when sharing a key with an author, which public key is not a valid point.
+	InvalidKeyError = fmt.Errorf("invalid public key")
+)
+
+const (
+	// keyDomain is a domain separation tag of hashes deriving key ids and keys sealing collection keys.
+	keyDomain = "bec/key"
+
+	keySize   = 32 // size of collection keys, used with AES-256-GCM
+	keyIDSize = 8  // size of key ids, referencing keys from encrypted records
+)
+
+// Collection keys are symmetric keys encrypting data of records within a collection, so that relays
+// can store and forward them without being able to read them. A key is distributed in a key record
+// committed to the collection, which seals it to X25519 keys of all members, derived from their
+// ed25519 public keys. Records are always encrypted by the latest key shared with their author, so
+// sharing a new key with remaining members is enough to stop removed members from reading new records.
+// Keys can be shared only by the collection owner and its moderators, see OwnedCollection and Peer.Grant.
+//
+// Both key records and encrypted records are told apart from plain user data by their kind. Key record
+// layout: key id, ephemeral X25519 public key and a list of member public keys, each followed by the
+// collection key sealed to it. Encrypted record layout: key id, nonce and ciphertext of the data,
+// authenticated together with the name of the collection and the record author, so that members
+// cannot pass records of others as their own.
+
+// keyShare is a collection key distribution decoded from a Record.
+type keyShare struct {
+	record    *Record
+	id        []byte
+	ephemeral []byte            // X25519 public key of the sender
+	sealed    map[string][]byte // sealed keys, by hex encoded ed25519 public keys of members
+}
+
+func encodeKeyShare(id []byte, ephemeral []byte, members []AuthorId, sealed [][]byte) []byte {
+	var buf bytes.Buffer
+	var inlined [binary.MaxVarintLen64]byte
+	buf.Write(id)
+	buf.Write(ephemeral)
+	n := binary.PutUvarint(inlined[:], uint64(len(members)))
+	buf.Write(inlined[:n])
+	for i, member := range members {
+		buf.Write(member)
+		buf.Write(sealed[i])
+	}
+	return buf.Bytes()
+}
+
+// decodeKeyShare returns a key distribution carried by a given Record, or false if it's not a key record.
+func decodeKeyShare(rec *Record) (*keyShare, bool) {
+	if rec.version < RecordV5 || rec.kind != kindKey {
+		return nil, false
+	}
+	r := bufio.NewReader(bytes.NewReader(rec.data))
+	ks := &keyShare{record: rec, id: make([]byte, keyIDSize), ephemeral: make([]byte, 32)}
+	if _, err := io.ReadFull(r, ks.id); err != nil {
+		return nil, false
+	}
+	if _, err := io.ReadFull(r, ks.ephemeral); err != nil {
+		return nil, false
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(len(rec.data)) {
+		return nil, false
+	}
+	ks.sealed = make(map[string][]byte, n)
+	for i := uint64(0); i < n; i++ {
+		member := make([]byte, ed25519.PublicKeySize)
+		sealed := make([]byte, keySize+16) // key followed by GCM tag
+		if _, err = io.ReadFull(r, member); err != nil {
+			return nil, false
+		}
+		if _, err = io.ReadFull(r, sealed); err != nil {
+			return nil, false
+		}
+		ks.sealed[hex.EncodeToString(member)] = sealed
+	}
+	return ks, true
+}
+
+// Encrypted checks if the data of a Record has been encrypted by a collection key. Such data can be
+// read only by Peer.Decrypt of collection members.
+func (r *Record) Encrypted() bool {
+	return r.version >= RecordV5 && r.kind == kindEncrypted
+}
+
+// ShareKey generates a new key of a collection with a given name and commits a record sealing it to
+// given members, as well as to the current peer. Records encrypted afterwards use the new key, so
+// authors left out cannot read them, even if they've been given previous keys. Returns
+// UnauthorizedError if the current peer has no moderation rights over the collection.
+func (p *Peer) ShareKey(collection string, members ...AuthorId) error {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	data, err := sealKey(key, append([]AuthorId{p.pub}, members...))
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.next(collection, kindKey, data)
+	if err = p.checkACL(c); err != nil {
+		return err
+	}
+	return p.commit(c) // the key becomes available once the record gets indexed
+}
+
+// sealKey returns data of a key record sealing a given collection key to given members.
+func sealKey(key []byte, members []AuthorId) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := keyID(key)
+	sealed := make([][]byte, len(members))
+	for i, member := range members {
+		pub, err := x25519Public(member)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", InvalidKeyError, err)
+		}
+		aead := newAEAD(wrapKey(shared, ephemeral.PublicKey().Bytes(), pub.Bytes()))
+		// every wrapping key seals just a single collection key, so the nonce doesn't need to be unique
+		sealed[i] = aead.Seal(nil, make([]byte, aead.NonceSize()), key, id)
+	}
+	return encodeKeyShare(id, ephemeral.PublicKey().Bytes(), members, sealed), nil
+}
+
+// CommitEncrypted creates a new record in a collection with a given name, which data is encrypted by
+// the latest key of the collection shared with the current peer. Returns KeyNotFoundError if there's
+// no such key.
+func (p *Peer) CommitEncrypted(collection string, data []byte) (*Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	latest, ok := p.shares[collection]
+	if !ok {
+		return nil, KeyNotFoundError
+	}
+	aead := newAEAD(p.keys[hex.EncodeToString(latest.id)])
+	var buf bytes.Buffer
+	buf.Write(latest.id)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	buf.Write(nonce)
+	if p.interest.collections != nil {
+		p.interest.collections[collection] = struct{}{}
+	}
+	c := p.next(collection, kindEncrypted, aead.Seal(buf.Bytes(), nonce, data, additionalData(collection, p.pub)))
+	if err := p.commit(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Decrypt returns data of a given record, decrypting it if it has been encrypted by a collection key.
+// Returns KeyNotFoundError if the key hasn't been shared with the current peer.
+func (p *Peer) Decrypt(r *Record) ([]byte, error) {
+	if !r.Encrypted() {
+		return r.data, nil
+	}
+	payload := r.data
+	if len(payload) < keyIDSize {
+		return nil, DecryptionError
+	}
+	id, payload := payload[:keyIDSize], payload[keyIDSize:]
+	p.mu.RLock()
+	key, ok := p.keys[hex.EncodeToString(id)]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, KeyNotFoundError
+	}
+	aead := newAEAD(key)
+	if len(payload) < aead.NonceSize() {
+		return nil, DecryptionError
+	}
+	data, err := aead.Open(nil, payload[:aead.NonceSize()], payload[aead.NonceSize():], additionalData(r.coll, r.author))
+	if err != nil {
+		return nil, DecryptionError
+	}
+	return data, nil
+}
+
+// indexKey opens a key of a committed key record, if it has been shared with the current peer. Caller
+// must hold a write lock.
+func (p *Peer) indexKey(r *Record) {
+	ks, ok := decodeKeyShare(r)
+	if !ok {
+		return
+	}
+	key := ks.open(p.pub, x25519Private(p.priv))
+	if key == nil {
+		return // key hasn't been shared with us
+	}
+	p.keys[hex.EncodeToString(ks.id)] = key
+	if latest, ok := p.shares[r.coll]; !ok || latest.record.Before(r) {
+		p.shares[r.coll] = ks
+	}
+}
+
+// open returns a collection key sealed to a given member, or nil if it cannot be opened.
+func (ks *keyShare) open(member AuthorId, priv *ecdh.PrivateKey) []byte {
+	sealed, ok := ks.sealed[hex.EncodeToString(member)]
+	if !ok {
+		return nil
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ks.ephemeral)
+	if err != nil {
+		return nil
+	}
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil
+	}
+	aead := newAEAD(wrapKey(shared, ks.ephemeral, priv.PublicKey().Bytes()))
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, ks.id)
+	if err != nil || !bytes.Equal(keyID(key), ks.id) {
+		return nil
+	}
+	return key
+}
+
+// additionalData returns data authenticated together with the data of an encrypted record. Authors
+// have a fixed size, so the collection name doesn't need a length prefix.
+func additionalData(collection string, author AuthorId) []byte {
+	return append([]byte(collection), author...)
+}
+
+// keyID returns an identifier of a collection key, referenced by records encrypted by it.
+func keyID(key []byte) []byte {
+	h := sha256.Sum256(append([]byte(keyDomain), key...))
+	return h[:keyIDSize]
+}
+
+// wrapKey derives a key sealing a collection key from a shared secret of both parties.
+func wrapKey(shared []byte, ephemeral []byte, recipient []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(keyDomain))
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	return h.Sum(nil)
+}
+
+func newAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // keys always have a valid size
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// x25519Private returns the X25519 private key of an ed25519 key pair, i.e. its secret scalar.
+func x25519Private(priv ed25519.PrivateKey) *ecdh.PrivateKey {
+	h := sha512.Sum512(priv.Seed())
+	key, _ := ecdh.X25519().NewPrivateKey(h[:32]) // scalar gets clamped by X25519 the same way ed25519 does
+	return key
+}
+
+// curve25519P is the order of the field of Curve25519, 2^255 - 19.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519Public returns the X25519 public key of an ed25519 one, mapping a point of the Edwards curve
+// onto the birationally equivalent Montgomery curve: u = (1 + y) / (1 - y).
+func x25519Public(pub AuthorId) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: %d bytes", InvalidKeyError, len(pub))
+	}
+	le := make([]byte, len(pub))
+	for i, b := range pub {
+		le[len(pub)-1-i] = b // big.Int is big-endian, keys are little-endian
+	}
+	le[0] &= 0x7f // the highest bit holds the sign of x
+	y := new(big.Int).SetBytes(le)
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("%w: identity point", InvalidKeyError)
+	}
+	u := num.Mul(num, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+	out := u.FillBytes(make([]byte, 32))
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return ecdh.X25519().NewPublicKey(out)
+}
+
+// ShareKey generates a new key of a collection with a given name on the underlying Peer, sealing it
+// to given members, and announces new heads to all connected remotes following that collection.
+func (c *PeerController) ShareKey(collection string, members ...AuthorId) error {
+	return c.Do(func(p *Peer) error {
+		if err := p.ShareKey(collection, members...); err != nil {
+			return err
+		}
+		c.announce()
+		return nil
+	})
+}
+
+// CommitEncrypted creates a new record with encrypted data in a collection with a given name on the
+// underlying Peer and announces new heads to all connected remotes following that collection.
+func (c *PeerController) CommitEncrypted(collection string, data []byte) (*Record, error) {
+	var r *Record
+	err := c.Do(func(p *Peer) error {
+		var err error
+		r, err = p.CommitEncrypted(collection, data)
+		if err != nil {
+			return err
+		}
+		c.announce()
+		return nil
+	})
+	return r, err
+}
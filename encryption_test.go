@@ -0,0 +1,136 @@
+package bec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestX25519Keys(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		p := newTestPeer(t)
+		pub, err := x25519Public(p.pub)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !pub.Equal(x25519Private(p.priv).PublicKey()) {
+			t.Fatalf("expected X25519 keys derived from both halves of ed25519 key pair to match")
+		}
+	}
+}
+
+func TestEncryptedRecords(t *testing.T) {
+	alice, bob, relay := newTestPeer(t), newTestPeer(t), newTestPeer(t)
+	c := OwnedCollection(alice.pub, "c")
+	if _, err := alice.CommitEncrypted(c, []byte("secret")); err != KeyNotFoundError {
+		t.Fatalf("expected encryption without a key to fail, got: %v", err)
+	}
+	if err := alice.ShareKey(c, bob.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := alice.CommitEncrypted(c, []byte("secret"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !r.Encrypted() || bytes.Contains(r.Data(), []byte("secret")) {
+		t.Fatalf("expected record data to be encrypted")
+	}
+	exchange(t, alice, relay)
+	exchange(t, relay, bob)
+	if _, err = relay.Decrypt(r); err != KeyNotFoundError {
+		t.Fatalf("expected relay not to read encrypted records, got: %v", err)
+	}
+	if data, err := bob.Decrypt(r); err != nil || string(data) != "secret" {
+		t.Fatalf("expected member to read encrypted records, got: %q, %v", data, err)
+	}
+	reply, err := bob.CommitEncrypted(c, []byte("reply"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if data, err := alice.Decrypt(reply); err != nil || string(data) != "reply" {
+		t.Fatalf("expected members to read each other's records, got: %q, %v", data, err)
+	}
+
+	// sharing a new key without bob removes him from the collection
+	exchange(t, alice, bob)
+	if err = alice.ShareKey(c); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r2, err := alice.CommitEncrypted(c, []byte("secret 2"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	exchange(t, alice, bob)
+	if _, err = bob.Decrypt(r2); err != KeyNotFoundError {
+		t.Fatalf("expected removed member not to read new records, got: %v", err)
+	}
+	if data, err := bob.Decrypt(r); err != nil || string(data) != "secret" {
+		t.Fatalf("expected removed member to keep reading old records, got: %q, %v", data, err)
+	}
+
+	f := *r
+	f.data = append([]byte{}, r.data...)
+	f.data[len(f.data)-1] ^= 1
+	if _, err = alice.Decrypt(&f); err != DecryptionError {
+		t.Fatalf("expected tampered record not to be decrypted, got: %v", err)
+	}
+	if data, err := alice.Decrypt(NewRecord(alice.pub, alice.priv, nil, []byte("plain"))); err != nil || string(data) != "plain" {
+		t.Fatalf("expected plain records to be returned as they are, got: %q, %v", data, err)
+	}
+	// another member cannot pass a copy of the ciphertext as their own
+	bob.mu.Lock()
+	copied := bob.next(c, kindEncrypted, r.data)
+	bob.mu.Unlock()
+	if _, err = alice.Decrypt(copied); err != DecryptionError {
+		t.Fatalf("expected ciphertext copied by another author not to be decrypted, got: %v", err)
+	}
+	// plain data looking like an encrypted payload is still plain
+	plain, err := alice.CommitTo(c, r.data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if data, err := alice.Decrypt(plain); plain.Encrypted() || err != nil || !bytes.Equal(data, r.data) {
+		t.Fatalf("expected records to be encrypted only by their kind, got: %v", err)
+	}
+}
+
+func TestShareKeyUnauthorized(t *testing.T) {
+	owner, mod, other := newTestPeer(t), newTestPeer(t), newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+	if err := owner.ShareKey(c, other.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	exchange(t, owner, other)
+	if err := other.ShareKey(c); err != UnauthorizedError {
+		t.Fatalf("expected members without moderation rights not to share keys, got: %v", err)
+	}
+	if _, err := other.CommitEncrypted(c, []byte("secret")); err != nil || len(other.keys) != 1 {
+		t.Fatalf("expected only the key shared by the owner to be used, got: %v", err)
+	}
+
+	// key record of a member is rejected by the owner as well
+	exchange(t, owner, other)
+	data, err := sealKey(make([]byte, keySize), []AuthorId{other.pub})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	forged := other.next(c, kindKey, data)
+	if err = owner.Integrate([]*Record{forged}); err != UnauthorizedError {
+		t.Fatalf("expected key record of a member to be rejected, got: %v", err)
+	}
+
+	if err = owner.Grant(c, mod.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	exchange(t, owner, mod)
+	if err = mod.ShareKey(c, owner.pub); err != nil {
+		t.Fatalf("expected moderators to share keys, got: %v", err)
+	}
+	exchange(t, owner, mod)
+	r, err := owner.CommitEncrypted(c, []byte("secret"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if data, err := mod.Decrypt(r); err != nil || string(data) != "secret" {
+		t.Fatalf("expected the key shared by the moderator to be used, got: %q, %v", data, err)
+	}
+}
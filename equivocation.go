@@ -0,0 +1,117 @@
+package bec
+
+import "encoding/hex"
+
+// Equivocation is an evidence of an author creating two concurrent records. Correct authors always
+// make their new records depend on the previous ones, so concurrent records by the same author mean
+// that it tried to show different histories to different peers.
+type Equivocation struct {
+	First  *Record // record committed first
+	Second *Record // record committed later, concurrent to the first one
+}
+
+// Author returns an author, who created both records.
+func (e Equivocation) Author() AuthorId {
+	return e.First.author
+}
+
+// Equivocations returns evidence of authors creating concurrent records, in the order it was found.
+// Only the first evidence is kept for every author.
+func (ms *MemStore) Equivocations() []Equivocation {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	res := make([]Equivocation, len(ms.evidence))
+	copy(res, ms.evidence)
+	return res
+}
+
+// Equivocated checks if there's an evidence of a given author creating concurrent records.
+func (ms *MemStore) Equivocated(author AuthorId) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	_, ok := ms.equivocators[hex.EncodeToString(author)]
+	return ok
+}
+
+// detect checks if a record at a given log position is concurrent to any of the latest records of
+// its author, recording the evidence if so. Caller must hold a write lock.
+func (ms *MemStore) detect(i int) {
+	r := ms.log[i]
+	if r.Pruned() {
+		return // tombstones are not signed, so they cannot serve as an evidence
+	}
+	author := hex.EncodeToString(r.author)
+	key := chainKey(r.author, r.coll) // records of different collections are never causally related
+	deps := ms.indexes(r.deps)
+	var latest []int
+	for _, h := range ms.authors[key] {
+		if ms.reaches(deps, h) {
+			continue // r has been created after h
+		}
+		latest = append(latest, h)
+		if _, ok := ms.equivocators[author]; ok {
+			continue // a single evidence is enough
+		}
+		if prev := ms.log[h]; !prev.Pruned() {
+			ms.evidence = append(ms.evidence, Equivocation{First: prev, Second: r})
+			ms.equivocators[author] = struct{}{}
+		}
+	}
+	ms.authors[key] = append(latest, i)
+}
+
+// reaches checks if a record at a target log position is one of the records at given positions or
+// their predecessors. Since log is in causal order, records preceding the target are not visited.
+func (ms *MemStore) reaches(from []int, target int) bool {
+	visited := make(map[int]struct{})
+	stack := from
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i == target {
+			return true
+		}
+		if _, ok := visited[i]; ok || i < target {
+			continue
+		}
+		visited[i] = struct{}{}
+		stack = append(stack, ms.indexes(ms.log[i].deps)...)
+	}
+	return false
+}
+
+// BlockEquivocators makes Peer drop records created by authors, who have been caught creating
+// concurrent records, as well as all of their successors. Since peers may find the evidence at
+// different times, they may end up with different records of such authors. The evidence is provided
+// by the store, so nothing is blocked unless it's an EquivocationStore.
+func BlockEquivocators() PeerOption {
+	return func(p *Peer) {
+		p.blocked = make(map[string]struct{})
+	}
+}
+
+// block checks if a record should be dropped according to the equivocation policy, remembering it
+// if so. Caller must hold a write lock.
+func (p *Peer) block(r *Record) bool {
+	if p.blocked == nil {
+		return false
+	}
+	es, ok := p.store.(EquivocationStore)
+	block := ok && es.Equivocated(r.author)
+	for _, dep := range r.deps {
+		if block {
+			break
+		}
+		_, block = p.blocked[hex.EncodeToString(dep)]
+	}
+	if block {
+		p.blocked[hex.EncodeToString(r.id)] = struct{}{}
+	}
+	return block
+}
+
+// isBlocked checks if a record with a given id has been dropped. Caller must hold at least a read lock.
+func (p *Peer) isBlocked(id ID) bool {
+	_, ok := p.blocked[hex.EncodeToString(id)]
+	return ok
+}
@@ -0,0 +1,53 @@
+package bec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestBlockEquivocators(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, NewMemStore(), BlockEquivocators())
+	evil := newTestPeer(t)
+	honest := newTestPeer(t)
+
+	a := NewRecord(evil.pub, evil.priv, nil, []byte("A"))
+	b := NewRecord(evil.pub, evil.priv, []ID{a.id}, []byte("B"))
+	c := NewRecord(evil.pub, evil.priv, []ID{a.id}, []byte("C"))
+	if err := p.Integrate([]*Record{a, b, c}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !p.store.Contains(c.id) || !p.store.(*MemStore).Equivocated(evil.pub) {
+		t.Fatalf("expected both concurrent records to be committed as an evidence")
+	}
+
+	// descendant of a blocked record arrives before it
+	d := NewRecord(evil.pub, evil.priv, []ID{b.id, c.id}, []byte("D"))
+	e := NewRecord(honest.pub, honest.priv, []ID{d.id}, []byte("E"))
+	if err := p.Integrate([]*Record{e}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !sameIDs(p.MissingDeps(), []ID{d.id}) {
+		t.Fatalf("expected missing dependency to be requested")
+	}
+	if err := p.Integrate([]*Record{d}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if p.store.Contains(d.id) || len(p.MissingDeps()) != 0 || len(p.NotFound([]ID{d.id})) != 0 {
+		t.Fatalf("expected record of equivocating author to be dropped and not requested again")
+	}
+
+	// descendant of a blocked record arrives after it
+	f := NewRecord(honest.pub, honest.priv, []ID{d.id}, []byte("F"))
+	g := NewRecord(honest.pub, honest.priv, []ID{c.id}, []byte("G"))
+	if err := p.Integrate([]*Record{f, g}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if p.store.Contains(f.id) || !p.store.Contains(g.id) || len(p.NotFound([]ID{f.id})) != 0 {
+		t.Fatalf("expected only descendants of blocked records to be dropped")
+	}
+}
@@ -0,0 +1,267 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// tombstoneMagic precedes tombstones of pruned records in the log file. They are written as the
+// record id followed by the list of its dependencies.
+const tombstoneMagic = "\x00BET"
+
+var (
+	_ PrunableStore     = (*FileStore)(nil)
+	_ CollectionStore   = (*FileStore)(nil)
+	_ SeqStore          = (*FileStore)(nil)
+	_ EquivocationStore = (*FileStore)(nil)
+)
+
+// FileStore is a durable Store backed by an append-only file. Every committed Record is appended
+// to the file in the Record.Write format and flushed to disk before Commit returns. All reads are
+// served from memory, which is rebuilt by replaying the file when the store is opened. FileStore
+// is safe for concurrent use.
+//
+// Pruning records rewrites the whole file, replacing pruned records with their tombstones.
+type FileStore struct {
+	mu   sync.Mutex // serializes writes to the log file
+	mem  *MemStore  // in-memory view over the file contents
+	path string     // path to the log file
+	file *os.File   // append-only log file
+	size int64      // size of the log file, which ends at the last fully written Record
+}
+
+// OpenFileStore opens or creates a FileStore at a given path. Records found in the file are
+// replayed to rebuild the store. If the last Record was only partially written (e.g. because
+// the process crashed in the middle of a Commit), it's truncated from the file.
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileStore{
+		mem:  NewMemStore(),
+		path: path,
+		file: f,
+	}
+	if err = fs.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// countingReader tracks the number of bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (fs *FileStore) replay() error {
+	cr := &countingReader{r: fs.file}
+	r := bufio.NewReader(cr)
+	for {
+		offset := cr.n - int64(r.Buffered()) // position at which current Record starts
+		rec, err := readEntry(r)
+		if err == io.EOF {
+			fs.size = offset
+			break
+		} else if errors.Is(err, TruncatedError) {
+			// torn write: file ends in the middle of a record, drop it
+			if err = fs.file.Truncate(offset); err != nil {
+				return err
+			}
+			fs.size = offset
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read record at offset %d: %w", offset, err)
+		}
+		if err = fs.mem.Commit(rec); err != nil {
+			return fmt.Errorf("failed to replay record at offset %d: %w", offset, err)
+		}
+	}
+	_, err := fs.file.Seek(fs.size, io.SeekStart)
+	return err
+}
+
+// Commit appends a Record to the log file and waits for it to be flushed to disk.
+func (fs *FileStore) Commit(r *Record) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// only committing writes to mem, so records checked here cannot change until the append below
+	fs.mem.mu.RLock()
+	err := fs.mem.check(r)
+	fs.mem.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeEntry(&buf, r); err != nil {
+		return err
+	}
+	n, err := fs.file.Write(buf.Bytes())
+	if err == nil {
+		err = fs.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			// don't leave partially written record behind, so that next commits can be appended
+			_ = fs.file.Truncate(fs.size)
+			_, _ = fs.file.Seek(fs.size, io.SeekStart)
+		}
+		return err
+	}
+	fs.size += int64(n)
+	fs.mem.mu.Lock()
+	fs.mem.append(r)
+	fs.mem.mu.Unlock()
+	return nil
+}
+
+// Prune replaces records with given ids with their tombstones. The log file is rewritten into
+// a temporary file, which then replaces the original one.
+func (fs *FileStore) Prune(ids []ID) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mem.mu.RLock()
+	log := fs.mem.tombstones(ids)
+	fs.mem.mu.RUnlock()
+
+	tmp := fs.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := writeLog(f, log)
+	if err == nil {
+		err = os.Rename(tmp, fs.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	fs.file.Close()
+	fs.file = f
+	fs.size = size
+
+	fs.mem.mu.Lock()
+	fs.mem.log = log
+	fs.mem.mu.Unlock()
+	return nil
+}
+
+// writeLog writes all log entries into a given file and flushes it to disk, returning the file size.
+func writeLog(f *os.File, log []*Record) (int64, error) {
+	w := bufio.NewWriter(f)
+	for _, r := range log {
+		if err := writeEntry(w, r); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekCurrent)
+}
+
+// writeEntry writes either a Record or its tombstone into the log file.
+func writeEntry(w io.Writer, r *Record) error {
+	if !r.Pruned() {
+		return r.Write(w)
+	}
+	if _, err := io.WriteString(w, tombstoneMagic); err != nil {
+		return err
+	}
+	return writeTombstone(w, r)
+}
+
+// readEntry reads either a Record or its tombstone from the log file.
+func readEntry(r *bufio.Reader) (*Record, error) {
+	header, _ := r.Peek(len(tombstoneMagic))
+	if string(header) != tombstoneMagic {
+		return Limits{}.ReadRecord(r) // file is written only by us, there's no need to limit it
+	}
+	_, _ = r.Discard(len(header))
+	return Limits{}.readTombstone(r)
+}
+
+// Close closes the underlying log file.
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.file.Close()
+}
+
+func (fs *FileStore) Get(id ID) *Record {
+	return fs.mem.Get(id)
+}
+
+func (fs *FileStore) GetMany(ids []ID) []*Record {
+	return fs.mem.GetMany(ids)
+}
+
+func (fs *FileStore) Contains(id ID) bool {
+	return fs.mem.Contains(id)
+}
+
+func (fs *FileStore) Heads() []ID {
+	return fs.mem.Heads()
+}
+
+func (fs *FileStore) Predecessors(heads []ID) []*Record {
+	return fs.mem.Predecessors(heads)
+}
+
+func (fs *FileStore) Missing(heads []ID) []*Record {
+	return fs.mem.Missing(heads)
+}
+
+func (fs *FileStore) LatestN(skip int, take int) []*Record {
+	return fs.mem.LatestN(skip, take)
+}
+
+func (fs *FileStore) Since(pos int, take int) []*Record {
+	return fs.mem.Since(pos, take)
+}
+
+func (fs *FileStore) Len() int {
+	return fs.mem.Len()
+}
+
+func (fs *FileStore) Equivocations() []Equivocation {
+	return fs.mem.Equivocations()
+}
+
+func (fs *FileStore) Equivocated(author AuthorId) bool {
+	return fs.mem.Equivocated(author)
+}
+
+func (fs *FileStore) LastSeq(author AuthorId, collection string) uint64 {
+	return fs.mem.LastSeq(author, collection)
+}
+
+func (fs *FileStore) ByAuthor(author AuthorId, collection string, seq uint64, take int) []*Record {
+	return fs.mem.ByAuthor(author, collection, seq, take)
+}
+
+func (fs *FileStore) HeadsOf(collection string) []ID {
+	return fs.mem.HeadsOf(collection)
+}
+
+func (fs *FileStore) Collections() []string {
+	return fs.mem.Collections()
+}
@@ -0,0 +1,201 @@
+package bec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestFileStore(t *testing.T, path string) *FileStore {
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to open file store: %s", err.Error())
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+// TestFileStore runs Store conformance suite against FileStore.
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return openTestFileStore(t, filepath.Join(t.TempDir(), "log"))
+	})
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	fs := openTestFileStore(t, path)
+	records := testRecords(pub, priv)
+	for _, r := range records {
+		if err := fs.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err = fs.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	fs = openTestFileStore(t, path)
+	ms := NewMemStore()
+	for _, r := range records {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	compareStores(fs, ms, t)
+	for i, r := range fs.LatestN(0, len(records)) {
+		if !bytes.Equal(r.id, records[i].id) {
+			t.Fatalf("replayed record at index %d doesn't match the original", i)
+		}
+	}
+	if !sameIDs(fs.Heads(), []ID{records[3].id, records[5].id}) {
+		t.Fatalf("heads were not restored")
+	}
+
+	// store is still writable after reopening
+	r := NewRecord(pub, priv, fs.Heads(), []byte("G"))
+	if err = fs.Commit(r); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestFileStoreTornRecord(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	records := testRecords(pub, priv)
+	var buf bytes.Buffer
+	if err = records[5].Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// F has a single dependency followed by its data, prefixed by a single byte length
+	inDeps := buf.Len() - len(records[5].data) - 1 - sha256.Size/2
+	for name, torn := range map[string]int{"half": buf.Len() / 2, "deps": inDeps} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			fs := openTestFileStore(t, path)
+			for _, r := range records[:5] {
+				if err := fs.Commit(r); err != nil {
+					t.Fatalf(err.Error())
+				}
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf(err.Error())
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			// simulate a crash in the middle of writing the last record
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if _, err = f.Write(buf.Bytes()[:torn]); err != nil {
+				t.Fatalf(err.Error())
+			}
+			f.Close()
+
+			fs = openTestFileStore(t, path)
+			if len(fs.LatestN(0, len(records))) != 5 {
+				t.Fatalf("expected torn record to be dropped")
+			}
+			truncated, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if truncated.Size() != info.Size() {
+				t.Fatalf("expected file to be truncated to %d bytes, but it has %d", info.Size(), truncated.Size())
+			}
+			if err = fs.Commit(records[5]); err != nil {
+				t.Fatalf(err.Error())
+			}
+		})
+	}
+}
+
+func TestFileStorePruneReopen(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	fs := openTestFileStore(t, path)
+	records := testRecords(pub, priv)
+	for _, r := range records[:4] {
+		if err := fs.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = fs.Prune([]ID{records[0].id, records[1].id}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, r := range records[4:] {
+		if err := fs.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err = fs.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if after.Size() >= before.Size()+int64(records[4].size()+records[5].size()) {
+		t.Fatalf("log file was not compacted")
+	}
+
+	fs = openTestFileStore(t, path)
+	if fs.Len() != len(records) || !fs.Contains(records[0].id) || fs.Get(records[0].id) != nil {
+		t.Fatalf("tombstones were not restored")
+	}
+	if res := fs.Missing(nil); len(res) != 4 || !bytes.Equal(res[3].id, records[5].id) {
+		t.Fatalf("records were not restored after pruning")
+	}
+}
+
+func TestFileStoreSequenceReopen(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	fs := openTestFileStore(t, path)
+	a := NewSequencedRecord(pub, priv, 1, nil, []byte("A"))
+	b := NewSequencedRecord(pub, priv, 2, []ID{a.id}, []byte("B"))
+	for _, r := range []*Record{a, b} {
+		if err := fs.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err = fs.Prune([]ID{a.id, b.id}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = fs.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	fs = openTestFileStore(t, path)
+	if seq := fs.LastSeq(pub, ""); seq != 2 {
+		t.Fatalf("expected sequence numbers of tombstones to be restored, got %d", seq)
+	}
+	c := NewSequencedRecord(pub, priv, 3, []ID{b.id}, []byte("C"))
+	if err = fs.Commit(c); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
@@ -1,3 +1,3 @@
 module bec
 
-go 1.19
+go 1.20
@@ -0,0 +1,142 @@
+package bec
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// GossipStrategy decides which remotes are contacted in a gossip round.
+type GossipStrategy int
+
+const (
+	// GossipRandom picks remotes at random.
+	GossipRandom GossipStrategy = iota
+	// GossipLeastRecent picks remotes, which have not been synchronized with for the longest time.
+	GossipLeastRecent
+)
+
+// GossipConfig describes how PeerController periodically synchronizes with connected remotes.
+type GossipConfig struct {
+	Interval   time.Duration // time between gossip rounds
+	Fanout     int           // maximum number of remotes contacted in a single round
+	Strategy   GossipStrategy
+	MinBackoff time.Duration // time a failing remote is skipped for, doubled with every consecutive failure
+	MaxBackoff time.Duration // maximum time a failing remote is skipped for
+}
+
+// DefaultGossipConfig is a GossipConfig suitable for most deployments.
+var DefaultGossipConfig = GossipConfig{
+	Interval:   5 * time.Second,
+	Fanout:     3,
+	Strategy:   GossipLeastRecent,
+	MinBackoff: time.Second,
+	MaxBackoff: 5 * time.Minute,
+}
+
+// WithGossip makes PeerController run gossip rounds: every interval it picks a few connected
+// remotes and sends them current heads together with a Bloom filter of recent records, so that
+// records are eventually spread to all peers, even if they are not directly connected to their
+// authors. Remotes, which keep failing or sending invalid messages, are skipped for an
+// exponentially growing period of time.
+func WithGossip(cfg GossipConfig) ControllerOption {
+	return func(c *PeerController) {
+		c.gossip = &cfg
+	}
+}
+
+// health describes the history of a remote peer. It's kept by its name, so that it survives
+// reconnections.
+type health struct {
+	synced   time.Time // last time remote was synchronized with
+	failures int       // number of consecutive failures
+	until    time.Time // remote is skipped by gossip rounds until then
+	score    int       // penalties of remote accumulated over all of its connections
+	banned   time.Time // remote cannot connect until then
+}
+
+// healthOf returns health of a remote with a given name. Caller must hold c.mu.
+func (c *PeerController) healthOf(name string) *health {
+	h, ok := c.health[name]
+	if !ok {
+		h = &health{}
+		c.health[name] = h
+	}
+	return h
+}
+
+// fail records a failure of a remote with a given name, backing off from it.
+func (c *PeerController) fail(name string) {
+	if c.gossip == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.healthOf(name)
+	h.failures++
+	backoff := c.gossip.MinBackoff
+	for i := 1; i < h.failures && backoff < c.gossip.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.gossip.MaxBackoff {
+		backoff = c.gossip.MaxBackoff
+	}
+	h.until = c.now().Add(backoff)
+}
+
+// succeed resets consecutive failures of a remote with a given name.
+func (c *PeerController) succeed(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.healthOf(name)
+	h.failures = 0
+	h.synced = c.now()
+}
+
+// pick returns remotes to be contacted in a gossip round. Caller must hold c.mu.
+func (c *PeerController) pick(now time.Time) []*remote {
+	var res []*remote
+	for name, r := range c.out {
+		if now.Before(c.healthOf(name).until) {
+			continue // backing off
+		}
+		res = append(res, r)
+	}
+	switch c.gossip.Strategy {
+	case GossipRandom:
+		c.rand.Shuffle(len(res), func(i, j int) {
+			res[i], res[j] = res[j], res[i]
+		})
+	case GossipLeastRecent:
+		sort.Slice(res, func(i, j int) bool {
+			a, b := c.health[res[i].name].synced, c.health[res[j].name].synced
+			if a.Equal(b) {
+				return res[i].name < res[j].name
+			}
+			return a.Before(b)
+		})
+	}
+	if c.gossip.Fanout > 0 && len(res) > c.gossip.Fanout {
+		res = res[:c.gossip.Fanout]
+	}
+	return res
+}
+
+// gossipRound sends sync messages to remotes picked for the current round.
+func (c *PeerController) gossipRound() {
+	now := c.now()
+	c.mu.Lock()
+	picked := c.pick(now)
+	for _, r := range picked {
+		c.healthOf(r.name).synced = now
+	}
+	c.mu.Unlock()
+	for _, r := range picked {
+		c.sync(r, r.known)
+	}
+}
+
+// newRand returns a source of randomness used to pick remotes.
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
@@ -0,0 +1,93 @@
+package bec
+
+import (
+	"testing"
+	"time"
+)
+
+func names(rs []*remote) []string {
+	res := make([]string, len(rs))
+	for i, r := range rs {
+		res[i] = r.name
+	}
+	return res
+}
+
+func sameNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGossipPick(t *testing.T) {
+	cfg := GossipConfig{Fanout: 2, Strategy: GossipLeastRecent, MinBackoff: time.Second, MaxBackoff: 4 * time.Second}
+	c := NewController(newTestPeer(t), WithGossip(cfg))
+	defer c.Close()
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	for _, name := range []string{"a", "b", "c"} {
+		if err := c.Connect(name, make(chan []byte), make(chan []byte, 16)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	c.healthOf("a").synced = now.Add(-2 * time.Second)
+	c.healthOf("b").synced = now.Add(-time.Second)
+
+	if res := names(c.pick(now)); !sameNames(res, []string{"c", "a"}) {
+		t.Fatalf("expected least recently synced remotes, got: %v", res)
+	}
+	c.fail("c")
+	c.fail("c")
+	if res := names(c.pick(now)); !sameNames(res, []string{"a", "b"}) {
+		t.Fatalf("expected failing remote to be skipped, got: %v", res)
+	}
+	if res := names(c.pick(now.Add(1500 * time.Millisecond))); !sameNames(res, []string{"a", "b"}) {
+		t.Fatalf("expected backoff to double after consecutive failures, got: %v", res)
+	}
+	if res := names(c.pick(now.Add(2 * time.Second))); !sameNames(res, []string{"c", "a"}) {
+		t.Fatalf("expected remote to be picked after backoff, got: %v", res)
+	}
+	for i := 0; i < 10; i++ {
+		c.fail("c")
+	}
+	if until := c.healthOf("c").until; !until.Equal(now.Add(cfg.MaxBackoff)) {
+		t.Fatalf("expected backoff to be capped, got: %v", until.Sub(now))
+	}
+	c.succeed("c")
+	c.fail("c")
+	if until := c.healthOf("c").until; !until.Equal(now.Add(cfg.MinBackoff)) {
+		t.Fatalf("expected backoff to be reset after success, got: %v", until.Sub(now))
+	}
+}
+
+func TestGossipPropagates(t *testing.T) {
+	cfg := DefaultGossipConfig
+	cfg.Interval = 10 * time.Millisecond
+	cs := make([]*PeerController, 3)
+	for i := range cs {
+		cs[i] = NewController(newTestPeer(t), WithGossip(cfg))
+		go cs[i].Process()
+		defer cs[i].Close()
+	}
+	// a - b - c: records committed on a reach c only through gossip of b
+	link(t, cs[0], "a", cs[1], "b")
+	link(t, cs[1], "b", cs[2], "c")
+	r, err := cs[0].Commit([]byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sameIDs(heads(t, cs[2]), []ID{r.id}) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("record didn't propagate in time")
+}
@@ -0,0 +1,292 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+)
+
+// Interest describes records replicated by a peer, so that peers with limited resources don't need
+// to hold the whole DAG. Records of collections, which are not followed, are not replicated at all.
+// Records of followed collections created by other authors are replicated only as their tombstones,
+// which form a skeleton of dependencies needed to integrate the matching records. Moderation and key
+// records are always replicated in full, so that permissions over followed collections can be
+// evaluated and their encrypted records read.
+type Interest struct {
+	Collections []string   // names of followed collections, nil means all collections
+	Authors     []AuthorId // authors of replicated records, nil means all authors
+}
+
+// Matches checks if a given record is replicated in full.
+func (in Interest) Matches(r *Record) bool {
+	return newInterest(in).matches(r)
+}
+
+// interest is an Interest indexed for matching records. Its nil sets match everything.
+type interest struct {
+	collections map[string]struct{}
+	authors     map[string]struct{} // hex encoded public keys
+}
+
+func newInterest(in Interest) interest {
+	var res interest
+	if in.Collections != nil {
+		res.collections = make(map[string]struct{}, len(in.Collections))
+		for _, name := range in.Collections {
+			res.collections[name] = struct{}{}
+		}
+	}
+	if in.Authors != nil {
+		res.authors = make(map[string]struct{}, len(in.Authors))
+		for _, author := range in.Authors {
+			res.authors[hex.EncodeToString(author)] = struct{}{}
+		}
+	}
+	return res
+}
+
+// export returns the Interest, which has been indexed.
+func (in interest) export() Interest {
+	var res Interest
+	if in.collections != nil {
+		res.Collections = sortedNames(in.collections)
+	}
+	if in.authors != nil {
+		res.Authors = []AuthorId{}
+		for _, key := range sortedNames(in.authors) {
+			author, _ := hex.DecodeString(key)
+			res.Authors = append(res.Authors, author)
+		}
+	}
+	return res
+}
+
+// follows checks if records of a collection with a given name are replicated.
+func (in interest) follows(collection string) bool {
+	if in.collections == nil {
+		return true
+	}
+	_, ok := in.collections[collection]
+	return ok
+}
+
+// matches checks if a given record is replicated in full.
+func (in interest) matches(r *Record) bool {
+	if !in.follows(r.coll) {
+		return false
+	}
+	if in.authors == nil {
+		return true
+	}
+	if _, ok := in.authors[hex.EncodeToString(r.author)]; ok {
+		return true
+	}
+	return r.interpreted() // records interpreted by peers are needed regardless of their authors
+}
+
+// serve returns records to be sent to a peer with this interest. Records of collections it doesn't
+// follow are dropped, while the ones it's not interested in are replaced by their skeletons. Records
+// older than RecordV6 can't be verified without their data, so they are sent in full.
+func (in interest) serve(records []*Record) []*Record {
+	if in.collections == nil && in.authors == nil {
+		return records
+	}
+	res := make([]*Record, 0, len(records))
+	for _, r := range records {
+		if !in.follows(r.coll) {
+			continue
+		}
+		if r.version >= RecordV6 && !in.matches(r) {
+			r = r.skeleton()
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// WithInterest makes Peer replicate only records matching a given Interest. Records, which don't match
+// it, are verified using their skeletons and committed as tombstones.
+func WithInterest(in Interest) PeerOption {
+	return func(p *Peer) {
+		p.interest = newInterest(in)
+	}
+}
+
+// Interest returns records replicated by this peer.
+func (p *Peer) Interest() Interest {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.interest.export()
+}
+
+// encodeInterest encodes a message with an Interest of a peer.
+func encodeInterest(in Interest) []byte {
+	return encodeMsg(MsgInterest, func(w io.Writer) error {
+		var inlined [binary.MaxVarintLen64 + 1]byte
+		// every list is preceded by a flag distinguishing an empty list from no list at all
+		writeHeader := func(present bool, n int) error {
+			if !present {
+				_, err := w.Write([]byte{0})
+				return err
+			}
+			inlined[0] = 1
+			m := binary.PutUvarint(inlined[1:], uint64(n))
+			_, err := w.Write(inlined[:m+1])
+			return err
+		}
+		if err := writeHeader(in.Collections != nil, len(in.Collections)); err != nil {
+			return err
+		}
+		for _, name := range in.Collections {
+			if err := writeString(w, name); err != nil {
+				return err
+			}
+		}
+		if err := writeHeader(in.Authors != nil, len(in.Authors)); err != nil {
+			return err
+		}
+		for _, author := range in.Authors {
+			if _, err := w.Write(author); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readInterest decodes an Interest written by encodeInterest.
+func (l Limits) readInterest(r *bufio.Reader) (interest, error) {
+	var res interest
+	readHeader := func() (bool, int, error) {
+		present, err := r.ReadByte()
+		if err != nil || present == 0 {
+			return false, 0, err
+		}
+		n, err := readLength(r, l.MaxIDs)
+		return true, n, err
+	}
+	present, n, err := readHeader()
+	if err != nil {
+		return res, truncated(err)
+	}
+	if present {
+		res.collections = make(map[string]struct{}, n)
+		for i := 0; i < n; i++ {
+			name, err := readString(r, l.MaxNameSize)
+			if err != nil {
+				return res, truncated(err)
+			}
+			res.collections[name] = struct{}{}
+		}
+	}
+	present, n, err = readHeader()
+	if err != nil {
+		return res, truncated(err)
+	}
+	if present {
+		res.authors = make(map[string]struct{}, n)
+		author := make([]byte, ed25519.PublicKeySize)
+		for i := 0; i < n; i++ {
+			if _, err = io.ReadFull(r, author); err != nil {
+				return res, truncated(err)
+			}
+			res.authors[hex.EncodeToString(author)] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+// encodeServed encodes records served to a remote as messages of at most max records and at most
+// size bytes each, zero meaning no limit. Records bigger than size are sent alone. Skeletons can't be
+// written as records, so they're sent in a MsgPartial message only when there are any. All messages
+// but the last one are sent as MsgMore, telling the remote that the reply continues. There's always
+// at least one message, even if there are no records.
+func encodeServed(records []*Record, max, size int) [][]byte {
+	partial := false
+	entries := make([][]byte, len(records))
+	for i, r := range records {
+		var buf bytes.Buffer
+		if r.isSkeleton() {
+			partial = true
+			_ = writeSkeleton(&buf, r) // writes to bytes.Buffer never fail
+		} else {
+			_ = r.Write(&buf)
+		}
+		entries[i] = buf.Bytes()
+	}
+	var msgs [][]byte
+	for len(msgs) == 0 || len(entries) > 0 {
+		// leave room for the number of entries and flags telling records and skeletons apart
+		n, total := 0, binary.MaxVarintLen64
+		for n < len(entries) && (max <= 0 || n < max) && (size <= 0 || n == 0 || total+len(entries[n])+1 <= size) {
+			total += len(entries[n]) + 1
+			n++
+		}
+		msgType := byte(MsgRecords)
+		if n < len(entries) {
+			msgType = MsgMore
+		} else if partial {
+			msgType = MsgPartial
+		}
+		msgs = append(msgs, encodeEntries(msgType, records[:n], entries[:n]))
+		records, entries = records[n:], entries[n:]
+	}
+	return msgs
+}
+
+// encodeEntries encodes a message with given records, which have been already written as entries.
+// Entries of messages other than MsgRecords are preceded by a flag telling records and skeletons apart.
+func encodeEntries(msgType byte, records []*Record, entries [][]byte) []byte {
+	return encodeMsg(msgType, func(w io.Writer) error {
+		var inlined [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(inlined[:], uint64(len(entries)))
+		if _, err := w.Write(inlined[:n]); err != nil {
+			return err
+		}
+		for i, e := range entries {
+			if msgType != MsgRecords {
+				flag := byte(0)
+				if records[i].isSkeleton() {
+					flag = 1
+				}
+				if _, err := w.Write([]byte{flag}); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readPartial decodes records and skeletons of a MsgPartial or MsgMore message written by encodeServed.
+func (l Limits) readPartial(r *bufio.Reader) ([]*Record, error) {
+	n, err := readLength(r, l.MaxRecords)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	res := make([]*Record, n)
+	for i := range res {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, truncated(err)
+		}
+		switch kind {
+		case 0:
+			res[i], err = l.ReadRecord(r)
+		case 1:
+			res[i], err = l.readSkeleton(r)
+		default:
+			err = MalformedMessageError
+		}
+		if err != nil {
+			return nil, truncated(err)
+		}
+	}
+	return res, nil
+}
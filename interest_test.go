@@ -0,0 +1,217 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestPeerInterest(t *testing.T) {
+	alice, bob := newTestPeer(t), newTestPeer(t)
+	a1, _ := alice.CommitTo("c", []byte("A1"))
+	exchange(t, alice, bob)
+	b1, _ := bob.CommitTo("c", []byte("B1"))
+	exchange(t, alice, bob)
+	a2, _ := alice.CommitTo("c", []byte("A2"))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	in := Interest{Collections: []string{"c"}, Authors: []AuthorId{alice.pub}}
+	p := NewPeer(pub, priv, NewMemStore(), WithInterest(in))
+	if got := p.Interest(); len(got.Collections) != 1 || len(got.Authors) != 1 {
+		t.Fatalf("unexpected interest: %+v", got)
+	}
+	if !in.Matches(a1) || in.Matches(b1) {
+		t.Fatalf("expected only records of followed authors to match")
+	}
+	if err = p.Integrate([]*Record{a1, b1, a2}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if p.store.Get(a1.id) == nil || p.store.Get(a2.id) == nil {
+		t.Fatalf("expected records of followed authors to be integrated")
+	}
+	if !p.store.Contains(b1.id) || p.store.Get(b1.id) != nil {
+		t.Fatalf("expected records of other authors to be kept only as tombstones")
+	}
+
+	served := newInterest(in).serve([]*Record{a1, b1, a2})
+	if len(served) != 3 || served[0] != a1 || !served[1].isSkeleton() || served[1].data != nil || served[2] != a2 {
+		t.Fatalf("expected records of other authors to be served as skeletons")
+	}
+	legacy := (&Record{version: RecordV5, author: bob.pub, coll: "c", data: []byte("B2")}).seal(bob.priv)
+	if res := newInterest(in).serve([]*Record{legacy}); len(res) != 1 || res[0] != legacy {
+		t.Fatalf("expected records older than RecordV6 to be served in full")
+	}
+	pub, priv, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	q := NewPeer(pub, priv, NewMemStore(), WithInterest(in))
+	if err = q.Integrate(served); err != nil {
+		t.Fatalf("expected skeleton to be integrated, got: %v", err)
+	}
+	if !sameIDs(q.Heads(), []ID{a2.id}) {
+		t.Fatalf("expected records of followed authors to be committed on top of the skeleton")
+	}
+	if q.store.Get(b1.id) != nil || !q.store.Contains(b1.id) {
+		t.Fatalf("expected skeleton to be committed as a tombstone")
+	}
+	full := NewPeer(pub, priv, NewMemStore())
+	if err = full.Integrate(served); err != nil || full.store.Contains(b1.id) || full.store.Contains(a2.id) {
+		t.Fatalf("expected skeleton not to be committed by peers replicating everything, got: %v", err)
+	}
+
+	forged := *served[1]
+	forged.deps = nil
+	if err = NewPeer(pub, priv, NewMemStore(), WithInterest(in)).Integrate([]*Record{a1, &forged}); !errors.Is(err, HashMismatchError) {
+		t.Fatalf("expected skeleton with forged dependencies to be rejected, got: %v", err)
+	}
+	forged = *served[1]
+	forged.digest = make([]byte, len(forged.digest))
+	if err = NewPeer(pub, priv, NewMemStore(), WithInterest(in)).Integrate([]*Record{a1, &forged}); !errors.Is(err, HashMismatchError) {
+		t.Fatalf("expected skeleton with forged digest to be rejected, got: %v", err)
+	}
+}
+
+func TestInterestModeration(t *testing.T) {
+	owner, mod := newTestPeer(t), newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+	if err := owner.Grant(c, mod.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, _ := owner.CommitTo(c, []byte("A"))
+	in := newInterest(Interest{Authors: []AuthorId{mod.pub}})
+	grant := owner.store.Get(r.deps[0])
+	if !in.matches(grant) || in.matches(r) {
+		t.Fatalf("expected moderation records to match regardless of their authors")
+	}
+	if err := owner.ShareKey(c, mod.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if share := owner.store.Get(owner.HeadsOf(c)[0]); !in.matches(share) {
+		t.Fatalf("expected key records to match regardless of their authors")
+	}
+}
+
+func TestControllerInterest(t *testing.T) {
+	c1 := NewController(newTestPeer(t))
+	go c1.Process()
+	defer c1.Close()
+	author := c1.peer.pub
+	other := newTestPeer(t)
+	a, err := c1.CommitTo("c", []byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	b := (&Record{version: LatestRecordVersion, author: other.pub, seq: 1, deps: []ID{a.id}, coll: "c", data: []byte("B")}).seal(other.priv)
+	if err = c1.peer.Integrate([]*Record{b}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	a2, err := c1.CommitTo("c", []byte("A2"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = c1.CommitTo("d", []byte("D")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p2 := NewPeer(pub, priv, NewMemStore(), WithInterest(Interest{Collections: []string{"c"}, Authors: []AuthorId{author}}))
+	c2 := NewController(p2)
+	go c2.Process()
+	defer c2.Close()
+	link(t, c1, "c1", c2, "c2")
+	eventually(t, "records of followed authors are replicated", func() bool {
+		return sameIDs(heads(t, c2), []ID{a2.id})
+	})
+	if p2.store.Get(a.id) == nil || p2.store.Get(b.id) != nil || !p2.store.Contains(b.id) {
+		t.Fatalf("expected only the skeleton of records of other authors to be replicated")
+	}
+	if names := p2.Collections(); len(names) != 1 || names[0] != "c" {
+		t.Fatalf("expected records of other collections not to be replicated, got: %v", names)
+	}
+}
+
+func TestInterestReadWrite(t *testing.T) {
+	p := newTestPeer(t)
+	a, _ := p.CommitTo("c", []byte("A"))
+	b, _ := p.CommitTo("c", []byte("B"))
+	in := Interest{Collections: []string{"c", "d"}, Authors: []AuthorId{p.pub}}
+	msg := encodeInterest(in)
+	got, err := DefaultLimits.readInterest(bufio.NewReader(bytes.NewReader(msg[1:])))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if e := got.export(); len(e.Collections) != 2 || len(e.Authors) != 1 || !bytes.Equal(e.Authors[0], p.pub) {
+		t.Fatalf("unexpected interest: %+v", e)
+	}
+	if all, _ := DefaultLimits.readInterest(bufio.NewReader(bytes.NewReader(encodeInterest(Interest{})[1:]))); all.collections != nil || all.authors != nil {
+		t.Fatalf("expected missing lists to match everything")
+	}
+
+	msg = encodeServed([]*Record{a.skeleton(), b}, 0, 0)[0]
+	if msg[0] != MsgPartial {
+		t.Fatalf("expected skeletons to be sent in a partial message")
+	}
+	records, err := DefaultLimits.readPartial(bufio.NewReader(bytes.NewReader(msg[1:])))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(records) != 2 || !records[0].isSkeleton() || !bytes.Equal(records[0].id, a.id) || !bytes.Equal(records[1].data, b.data) {
+		t.Fatalf("unexpected records: %v", records)
+	}
+}
+
+func TestEncodeServedBatches(t *testing.T) {
+	p := newTestPeer(t)
+	var records []*Record
+	for i := 0; i < 5; i++ {
+		r, _ := p.CommitTo("c", []byte{byte(i)})
+		records = append(records, r)
+	}
+	records[1] = records[1].skeleton()
+	size := 0
+	for _, msg := range encodeServed(records[:2], 0, 0) {
+		size = len(msg) - 1
+	}
+
+	var got []*Record
+	msgs := encodeServed(records, 2, size)
+	for i, msg := range msgs {
+		if len(msg)-1 > size {
+			t.Fatalf("message %d exceeds %d bytes: %d", i, size, len(msg)-1)
+		}
+		if last := i == len(msgs)-1; last != (msg[0] != MsgMore) {
+			t.Fatalf("unexpected type of message %d: %d", i, msg[0])
+		}
+		l := Limits{MaxRecords: 2}
+		batch, err := l.readPartial(bufio.NewReader(bytes.NewReader(msg[1:])))
+		if msg[0] == MsgRecords {
+			batch, err = l.ReadRecords(bufio.NewReader(bytes.NewReader(msg[1:])))
+		}
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		got = append(got, batch...)
+	}
+	if len(msgs) < 3 || len(got) != len(records) {
+		t.Fatalf("unexpected batches: %d messages with %d records", len(msgs), len(got))
+	}
+	for i, r := range got {
+		if !bytes.Equal(r.id, records[i].id) || r.isSkeleton() != records[i].isSkeleton() {
+			t.Fatalf("unexpected record %d: %v", i, r)
+		}
+	}
+
+	if msgs := encodeServed(nil, 2, size); len(msgs) != 1 || msgs[0][0] != MsgRecords {
+		t.Fatalf("expected a single empty message, got %d", len(msgs))
+	}
+}
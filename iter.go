@@ -0,0 +1,160 @@
+package bec
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/hex"
+)
+
+// Iterator walks over records of a Store in a deterministic topological order. Whenever several
+// records can be visited next, the one with the lowest ID goes first, so that every replica with
+// the same records visits them in exactly the same order. Tombstones are not visited, but they
+// are ordered like any other record, so that they still order the records around them.
+type Iterator struct {
+	ready   recordHeap           // records which can be visited next, ordered by their ids
+	blocked map[string]int       // number of records, which must be visited before a record with given id
+	unlocks map[string][]*Record // records waiting for a record with given id to be visited
+}
+
+// IterateForward returns an Iterator over given roots and all of their successors, visiting every
+// record after all of its dependencies. If roots are nil, all records in the store are visited.
+func IterateForward(s Store, roots []ID) *Iterator {
+	rs := s.Since(0, s.Len()) // all records including tombstones in causal order
+	if roots != nil {
+		included := make(map[string]struct{}, len(roots))
+		for _, id := range roots {
+			included[hex.EncodeToString(id)] = struct{}{}
+		}
+		var successors []*Record
+		for _, r := range rs {
+			key := hex.EncodeToString(r.id)
+			_, include := included[key]
+			for _, dep := range r.deps {
+				if include {
+					break
+				}
+				_, include = included[hex.EncodeToString(dep)]
+			}
+			if include {
+				included[key] = struct{}{}
+				successors = append(successors, r)
+			}
+		}
+		rs = successors
+	}
+	return newIterator(rs, true)
+}
+
+// IterateBackward returns an Iterator over given heads and all of their predecessors, visiting every
+// record before any of its dependencies. If heads are nil, current heads of the store are used.
+func IterateBackward(s Store, heads []ID) *Iterator {
+	if heads == nil {
+		heads = s.Heads()
+	}
+	return newIterator(predecessors(s.Since(0, s.Len()), heads), false)
+}
+
+// predecessors returns records of given heads and all of their predecessors found in a log of records
+// in causal order, including tombstones, which are skipped by Store.Predecessors.
+func predecessors(log []*Record, heads []ID) []*Record {
+	included := make(map[string]struct{}, len(heads))
+	for _, id := range heads {
+		included[hex.EncodeToString(id)] = struct{}{}
+	}
+	var res []*Record
+	for i := len(log) - 1; i >= 0; i-- {
+		if _, ok := included[hex.EncodeToString(log[i].id)]; ok {
+			for _, dep := range log[i].deps {
+				included[hex.EncodeToString(dep)] = struct{}{}
+			}
+			res = append(res, log[i])
+		}
+	}
+	return res
+}
+
+// newIterator returns an Iterator over a given set of records. If forward is true, records are
+// visited after their dependencies, otherwise before them. Dependencies outside the set are ignored.
+func newIterator(rs []*Record, forward bool) *Iterator {
+	it := &Iterator{
+		blocked: make(map[string]int, len(rs)),
+		unlocks: make(map[string][]*Record),
+	}
+	byID := make(map[string]*Record, len(rs))
+	for _, r := range rs {
+		byID[hex.EncodeToString(r.id)] = r
+	}
+	for _, r := range rs {
+		key := hex.EncodeToString(r.id)
+		for _, dep := range r.deps {
+			depKey := hex.EncodeToString(dep)
+			d, ok := byID[depKey]
+			if !ok {
+				continue
+			}
+			if forward {
+				it.blocked[key]++
+				it.unlocks[depKey] = append(it.unlocks[depKey], r)
+			} else {
+				it.blocked[depKey]++
+				it.unlocks[key] = append(it.unlocks[key], d)
+			}
+		}
+	}
+	for key, r := range byID {
+		if it.blocked[key] == 0 {
+			it.ready = append(it.ready, r)
+		}
+	}
+	heap.Init(&it.ready)
+	return it
+}
+
+// Next returns the next Record or nil once all records have been visited.
+func (it *Iterator) Next() *Record {
+	for it.ready.Len() > 0 {
+		r := heap.Pop(&it.ready).(*Record)
+		key := hex.EncodeToString(r.id)
+		for _, o := range it.unlocks[key] {
+			k := hex.EncodeToString(o.id)
+			it.blocked[k]--
+			if it.blocked[k] == 0 {
+				delete(it.blocked, k)
+				heap.Push(&it.ready, o)
+			}
+		}
+		delete(it.unlocks, key)
+		if !r.Pruned() {
+			return r
+		}
+	}
+	return nil
+}
+
+// All returns all remaining records in the order they would be visited.
+func (it *Iterator) All() []*Record {
+	var res []*Record
+	for r := it.Next(); r != nil; r = it.Next() {
+		res = append(res, r)
+	}
+	return res
+}
+
+// recordHeap is a min-heap of records ordered by their ids.
+type recordHeap []*Record
+
+func (h recordHeap) Len() int           { return len(h) }
+func (h recordHeap) Less(i, j int) bool { return bytes.Compare(h[i].id, h[j].id) < 0 }
+func (h recordHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *recordHeap) Push(x any) {
+	*h = append(*h, x.(*Record))
+}
+
+func (h *recordHeap) Pop() any {
+	old := *h
+	n := len(old)
+	r := old[n-1]
+	*h = old[:n-1]
+	return r
+}
@@ -0,0 +1,138 @@
+package bec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+// topological checks if records are listed after (or before if reversed) all of their dependencies.
+func topological(rs []*Record, reversed bool) bool {
+	seen := make(map[string]struct{})
+	for i := range rs {
+		r := rs[i]
+		if reversed {
+			r = rs[len(rs)-1-i]
+		}
+		for _, dep := range r.deps {
+			if _, ok := seen[hex.EncodeToString(dep)]; !ok {
+				return false
+			}
+		}
+		seen[hex.EncodeToString(r.id)] = struct{}{}
+	}
+	return true
+}
+
+func sameRecords(a []*Record, b []*Record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].id, b[i].id) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIterateDeterministic(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	records := testRecords(pub, priv)
+	a, b, c, d, e, f := records[0], records[1], records[2], records[3], records[4], records[5]
+	s1, s2 := NewMemStore(), NewMemStore()
+	for _, r := range []*Record{a, b, c, d, e, f} {
+		if err := s1.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for _, r := range []*Record{a, c, b, e, f, d} {
+		if err := s2.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	forward := IterateForward(s1, nil).All()
+	if len(forward) != len(records) || !topological(forward, false) {
+		t.Fatalf("forward iteration is not a topological order")
+	}
+	if !sameRecords(forward, IterateForward(s2, nil).All()) {
+		t.Fatalf("forward iteration differs between stores")
+	}
+	// B and C are concurrent, so the one with lower id goes first
+	first, second := b, c
+	if bytes.Compare(c.id, b.id) < 0 {
+		first, second = c, b
+	}
+	if forward[1] != first || (forward[2] != second && forward[2] != d) {
+		t.Fatalf("concurrent records are not ordered by their ids")
+	}
+
+	backward := IterateBackward(s1, nil).All()
+	if len(backward) != len(records) || !topological(backward, true) {
+		t.Fatalf("backward iteration is not a reverse topological order")
+	}
+	if !sameRecords(backward, IterateBackward(s2, nil).All()) {
+		t.Fatalf("backward iteration differs between stores")
+	}
+}
+
+func TestIterateSubset(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms := NewMemStore()
+	records := testRecords(pub, priv)
+	for _, r := range records {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	a, b, c, d, e, f := records[0], records[1], records[2], records[3], records[4], records[5]
+
+	if res := IterateForward(ms, []ID{c.id}).All(); !sameRecords(res, []*Record{c, e, f}) {
+		t.Fatalf("unexpected successors of C")
+	}
+	if res := IterateBackward(ms, []ID{d.id}).All(); !sameRecords(res, []*Record{d, b, a}) {
+		t.Fatalf("unexpected predecessors of D")
+	}
+	if res := IterateForward(ms, []ID{}).All(); len(res) != 0 {
+		t.Fatalf("expected no records for empty roots")
+	}
+}
+
+func TestIteratePruned(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := NewRecord(pub, priv, nil, []byte("A"))
+	b := NewRecord(pub, priv, []ID{a.id}, []byte("B"))
+	// make C go before A, if the pruned B didn't order them
+	var c *Record
+	for i := 0; c == nil || bytes.Compare(c.id, a.id) > 0; i++ {
+		c = NewRecord(pub, priv, []ID{b.id}, []byte{'C', byte(i)})
+	}
+	ms := NewMemStore()
+	for _, r := range []*Record{a, b.Tombstone(), c} {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	if res := IterateForward(ms, nil).All(); !sameRecords(res, []*Record{a, c}) {
+		t.Fatalf("expected records to be ordered through the tombstone")
+	}
+	if res := IterateForward(ms, []ID{b.id}).All(); !sameRecords(res, []*Record{c}) {
+		t.Fatalf("expected successors of the tombstone to be visited")
+	}
+	if res := IterateBackward(ms, nil).All(); !sameRecords(res, []*Record{c, a}) {
+		t.Fatalf("expected records to be ordered backward through the tombstone")
+	}
+}
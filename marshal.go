@@ -3,14 +3,90 @@ package bec
 import (
 	"bufio"
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 )
 
+var (
+	// TruncatedError happens when input ends in the middle of a decoded structure.
+	TruncatedError = fmt.Errorf("truncated input: %w", io.ErrUnexpectedEOF)
+
+	// LengthLimitError happens when a decoded length prefix exceeds the configured Limits.
+	LengthLimitError = fmt.Errorf("length exceeds the limit")
+
+	// HashLengthError happens when a Record identifier has a length other than the length of SHA256 hash.
+	HashLengthError = fmt.Errorf("invalid hash length")
+)
+
+// Limits bound the sizes of decoded structures, so that malicious peers cannot make us allocate
+// arbitrary amounts of memory by sending forged length prefixes. Zero means no limit.
+type Limits struct {
+	MaxDeps       int // maximum number of dependencies of a single Record
+	MaxDataSize   int // maximum size of a single Record data in bytes
+	MaxRecords    int // maximum number of records in a single list
+	MaxIDs        int // maximum number of identifiers in a single list
+	MaxBitmapSize int // maximum size of a Bitmap in bytes
+	MaxEntries    int // maximum number of records and tombstones in a Snapshot
+	MaxStateSize  int // maximum size of a Snapshot state in bytes
+	MaxNameSize   int // maximum length of a collection name in bytes
+}
+
+// DefaultLimits are the Limits used to decode data coming from untrusted sources.
+var DefaultLimits = Limits{
+	MaxDeps:       1 << 10,
+	MaxDataSize:   1 << 20,
+	MaxRecords:    1 << 14,
+	MaxIDs:        1 << 16,
+	MaxBitmapSize: 1 << 20,
+	MaxEntries:    1 << 22,
+	MaxStateSize:  64 << 20,
+	MaxNameSize:   1 << 10,
+}
+
+// recordMagic precedes every serialized Record, which is not in a legacy RecordV0 format. Legacy records
+// start directly with an author public key, so there's a 2^-32 chance for them to be misinterpreted.
+const recordMagic = "\x00BEC"
+
 func (r *Record) Write(w io.Writer) error {
-	var inlined [5]byte // inline buffer for variable length integers
+	var inlined [binary.MaxVarintLen64]byte // inline buffer for variable length integers
+	buf := inlined[:]
+	if err := r.writeHeader(w); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(buf, uint64(len(r.data)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(r.data)
+	return err
+}
+
+// writeSkeleton writes a skeleton of a Record, which has the data digest in place of the data.
+func writeSkeleton(w io.Writer, r *Record) error {
+	if err := r.writeHeader(w); err != nil {
+		return err
+	}
+	_, err := w.Write(r.dataDigest())
+	return err
+}
+
+// writeHeader writes all Record fields preceding its data.
+func (r *Record) writeHeader(w io.Writer) error {
+	var inlined [binary.MaxVarintLen64]byte // inline buffer for variable length integers
 	buf := inlined[:]
 
+	if r.version != RecordV0 {
+		// legacy records are written in their original format, without a version header
+		if _, err := io.WriteString(w, recordMagic); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{r.version}); err != nil {
+			return err
+		}
+	}
 	n, err := w.Write(r.author)
 	if err != nil {
 		return err
@@ -19,62 +95,270 @@ func (r *Record) Write(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	err = WriteIDs(r.deps, w)
+	if r.version >= RecordV2 {
+		n = binary.PutUvarint(buf, r.seq)
+		if _, err = w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	if r.version >= RecordV3 {
+		n = binary.PutUvarint(buf, r.time.Wall)
+		if _, err = w.Write(buf[:n]); err != nil {
+			return err
+		}
+		n = binary.PutUvarint(buf, uint64(r.time.Logical))
+		if _, err = w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	if r.version >= RecordV4 {
+		if err = writeString(w, r.coll); err != nil {
+			return err
+		}
+	}
+	if r.version >= RecordV5 {
+		if _, err = w.Write([]byte{r.kind}); err != nil {
+			return err
+		}
+	}
+	return WriteIDs(r.deps, w)
+}
+
+// ReadRecord reads a single Record written by Record.Write using DefaultLimits.
+func ReadRecord(r *bufio.Reader) (*Record, error) {
+	return DefaultLimits.ReadRecord(r)
+}
+
+// ReadRecord reads a single Record written by Record.Write. It returns io.EOF if the reader
+// was empty and TruncatedError if it ended in the middle of a Record.
+func (l Limits) ReadRecord(r *bufio.Reader) (*Record, error) {
+	p, err := l.readHeader(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	n = binary.PutUvarint(buf, uint64(len(r.data)))
-	n, err = w.Write(buf[:n])
+	dl, err := readLength(r, l.MaxDataSize)
 	if err != nil {
-		return err
+		return nil, truncated(err)
 	}
-	n, err = w.Write(r.data)
+	p.data = make([]byte, dl, dl)
+	_, err = io.ReadFull(r, p.data)
 	if err != nil {
-		return err
+		return nil, truncated(err)
 	}
-	return nil
+	p.id = p.hash() // hash was not serialized, we can infer it from content
+	if err = p.Verify(); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func ReadRecord(r *bufio.Reader) (*Record, error) {
+// readSkeleton reads a skeleton of a Record written by writeSkeleton.
+func (l Limits) readSkeleton(r *bufio.Reader) (*Record, error) {
+	p, err := l.readHeader(r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	p.digest = make([]byte, sha256.Size)
+	if _, err = io.ReadFull(r, p.digest); err != nil {
+		return nil, truncated(err)
+	}
+	p.id = p.hash()
+	if err = p.Verify(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readHeader reads all Record fields preceding its data written by Record.writeHeader. It returns
+// io.EOF if the reader was empty.
+func (l Limits) readHeader(r *bufio.Reader) (*Record, error) {
 	var inlined [ed25519.SignatureSize]byte
 	buf := inlined[:]
-	n, err := r.Read(buf[:ed25519.PublicKeySize])
-	if err != nil || n != ed25519.PublicKeySize {
+	version := RecordV0
+	header, err := r.Peek(len(recordMagic) + 1)
+	if len(header) == 0 {
 		return nil, err
 	}
+	if len(header) == len(recordMagic)+1 && string(header[:len(recordMagic)]) == recordMagic {
+		version = header[len(recordMagic)]
+		_, _ = r.Discard(len(header))
+	}
+	_, err = io.ReadFull(r, buf[:ed25519.PublicKeySize])
+	if err != nil {
+		return nil, truncated(err)
+	}
 	var author []byte
 	author = append(author, buf[:ed25519.PublicKeySize]...)
-	n, err = r.Read(buf[:ed25519.SignatureSize])
-	if err != nil || n != ed25519.SignatureSize {
-		return nil, err
+	_, err = io.ReadFull(r, buf[:ed25519.SignatureSize])
+	if err != nil {
+		return nil, truncated(err)
 	}
 	var sig []byte
 	sig = append(sig, buf[:ed25519.SignatureSize]...)
-	deps, err := ReadIDs(r)
+	var seq uint64
+	if version >= RecordV2 {
+		seq, err = binary.ReadUvarint(r)
+		if err != nil {
+			return nil, truncated(err)
+		}
+	}
+	var ts Timestamp
+	if version >= RecordV3 {
+		if ts, err = readTimestamp(r); err != nil {
+			return nil, truncated(err)
+		}
+	}
+	var coll string
+	if version >= RecordV4 {
+		if coll, err = readString(r, l.MaxNameSize); err != nil {
+			return nil, truncated(err)
+		}
+	}
+	var kind byte
+	if version >= RecordV5 {
+		if kind, err = r.ReadByte(); err != nil {
+			return nil, truncated(err)
+		}
+	}
+	deps, err := readIDs(r, l.MaxDeps)
 	if err != nil {
-		return nil, err
+		return nil, truncated(err)
 	}
-	dl, err := binary.ReadUvarint(r)
+	return &Record{
+		version: version,
+		author:  author,
+		sign:    sig,
+		deps:    deps,
+		seq:     seq,
+		time:    ts,
+		coll:    coll,
+		kind:    kind,
+	}, nil
+}
+
+// readTimestamp reads a Timestamp written by Record.Write.
+func readTimestamp(r *bufio.Reader) (Timestamp, error) {
+	wall, err := binary.ReadUvarint(r)
 	if err != nil {
-		return nil, err
+		return Timestamp{}, err
 	}
-	data := make([]byte, int(dl), int(dl))
-	n, err = r.Read(data)
-	if err != nil || n != int(dl) {
-		return nil, err
+	logical, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Timestamp{}, err
 	}
-	p := &Record{
-		id:     nil,
-		author: author,
-		sign:   sig,
-		deps:   deps,
-		data:   data,
+	if logical > math.MaxUint32 {
+		return Timestamp{}, fmt.Errorf("%w: logical clock %d", LengthLimitError, logical)
 	}
-	p.id = p.hash() // hash was not serialized, we can infer it from content
-	if err = p.Verify(); err != nil {
-		return nil, err
+	return Timestamp{Wall: wall, Logical: uint32(logical)}, nil
+}
+
+// writeTombstone writes a tombstone of a pruned Record.
+func writeTombstone(w io.Writer, r *Record) error {
+	var inlined [binary.MaxVarintLen64]byte // inline buffer for variable length integers
+	buf := inlined[:]
+	if _, err := w.Write(r.id); err != nil {
+		return err
 	}
-	return p, nil
+	if err := WriteIDs(r.deps, w); err != nil {
+		return err
+	}
+	// author may be missing, so it is prefixed with its length
+	n := binary.PutUvarint(buf, uint64(len(r.author)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	if _, err := w.Write(r.author); err != nil {
+		return err
+	}
+	n = binary.PutUvarint(buf, r.seq)
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	return writeString(w, r.coll)
+}
+
+// readTombstone reads a tombstone written by writeTombstone.
+func (l Limits) readTombstone(r *bufio.Reader) (*Record, error) {
+	id, err := readID(r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	deps, err := readIDs(r, l.MaxDeps)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	al, err := readLength(r, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	var author []byte
+	if al > 0 {
+		if al != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid author length %d", LengthLimitError, al)
+		}
+		author = make([]byte, al)
+		if _, err = io.ReadFull(r, author); err != nil {
+			return nil, truncated(err)
+		}
+	}
+	seq, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	coll, err := readString(r, l.MaxNameSize)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	return &Record{id: id, deps: deps, author: author, seq: seq, coll: coll}, nil
+}
+
+// writeString writes a string prefixed with its length.
+func writeString(w io.Writer, s string) error {
+	var inlined [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(inlined[:], uint64(len(s)))
+	if _, err := w.Write(inlined[:n]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// readString reads a string written by writeString, making sure it's not longer than a given limit.
+func readString(r *bufio.Reader, max int) (string, error) {
+	n, err := readLength(r, max)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return "", truncated(err)
+	}
+	return string(buf), nil
+}
+
+// truncated turns io.EOF into TruncatedError, as reader ended in the middle of the structure.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return TruncatedError
+	}
+	return err
+}
+
+// readLength reads a varint length prefix, making sure it doesn't exceed a given limit.
+// Limit equal to 0 means no limit.
+func readLength(r *bufio.Reader, max int) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	limit := uint64(math.MaxInt32) // lengths must fit into int on every platform
+	if max > 0 && uint64(max) < limit {
+		limit = uint64(max)
+	}
+	if n > limit {
+		return 0, fmt.Errorf("%w: %d exceeds limit of %d", LengthLimitError, n, limit)
+	}
+	return int(n), nil
 }
 
 func WriteRecords(rs []*Record, w io.Writer) error {
@@ -94,18 +378,24 @@ func WriteRecords(rs []*Record, w io.Writer) error {
 	return nil
 }
 
+// ReadRecords reads a list of records written by WriteRecords using DefaultLimits.
 func ReadRecords(r *bufio.Reader) ([]*Record, error) {
-	n, err := binary.ReadUvarint(r)
+	return DefaultLimits.ReadRecords(r)
+}
+
+// ReadRecords reads a list of records written by WriteRecords.
+func (l Limits) ReadRecords(r *bufio.Reader) ([]*Record, error) {
+	n, err := readLength(r, l.MaxRecords)
 	if err != nil {
 		return nil, err
 	}
-	res := make([]*Record, int(n), int(n))
-	for i := 0; i < int(n); i++ {
-		r, err := ReadRecord(r)
+	res := make([]*Record, n, n)
+	for i := 0; i < n; i++ {
+		rec, err := l.ReadRecord(r)
 		if err != nil {
-			return nil, err
+			return nil, truncated(err)
 		}
-		res[i] = r
+		res[i] = rec
 	}
 
 	return res, nil
@@ -115,6 +405,11 @@ func WriteIDs(ids []ID, w io.Writer) error {
 	var inlined [5]byte // inline buffer for variable length integers
 	buf := inlined[:]
 
+	for _, d := range ids {
+		if len(d) != sha256.Size {
+			return fmt.Errorf("%w: %d bytes", HashLengthError, len(d))
+		}
+	}
 	n := binary.PutUvarint(buf, uint64(len(ids)))
 	n, err := w.Write(buf[:n])
 	if err != nil {
@@ -129,22 +424,172 @@ func WriteIDs(ids []ID, w io.Writer) error {
 	return nil
 }
 
+// ReadIDs reads a list of identifiers written by WriteIDs using DefaultLimits.
 func ReadIDs(r *bufio.Reader) ([]ID, error) {
-	var inlined [ed25519.PublicKeySize]byte
-	buf := inlined[:]
+	return DefaultLimits.ReadIDs(r)
+}
 
-	n, err := binary.ReadUvarint(r)
+// ReadIDs reads a list of identifiers written by WriteIDs.
+func (l Limits) ReadIDs(r *bufio.Reader) ([]ID, error) {
+	return readIDs(r, l.MaxIDs)
+}
+
+func readIDs(r *bufio.Reader, max int) ([]ID, error) {
+	n, err := readLength(r, max)
 	if err != nil {
 		return nil, err
 	}
-	res := make([]ID, int(n), int(n))
-	for i := 0; i < int(n); i++ {
-		read, err := r.Read(buf[:ed25519.PublicKeySize])
-		if err != nil || read != ed25519.PublicKeySize {
-			return nil, err
+	res := make([]ID, n, n)
+	for i := 0; i < n; i++ {
+		if res[i], err = readID(r); err != nil {
+			return nil, truncated(err)
 		}
-		res[i] = append(ID{}, buf[:ed25519.PublicKeySize]...)
 	}
 
 	return res, nil
 }
+
+// readID reads a single identifier, which is always as long as SHA256 hash. Identifiers cut short
+// by the end of input are reported as both truncated and having invalid length.
+func readID(r *bufio.Reader) (ID, error) {
+	id := make(ID, sha256.Size)
+	n, err := io.ReadFull(r, id)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: %w of %d bytes", TruncatedError, HashLengthError, n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func WriteBitmap(b Bitmap, w io.Writer) error {
+	var inlined [5]byte // inline buffer for variable length integers
+	buf := inlined[:]
+
+	n := binary.PutUvarint(buf, uint64(len(b)))
+	_, err := w.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadBitmap reads a Bitmap written by WriteBitmap using DefaultLimits.
+func ReadBitmap(r *bufio.Reader) (Bitmap, error) {
+	return DefaultLimits.ReadBitmap(r)
+}
+
+// ReadBitmap reads a Bitmap written by WriteBitmap.
+func (l Limits) ReadBitmap(r *bufio.Reader) (Bitmap, error) {
+	n, err := readLength(r, l.MaxBitmapSize)
+	if err != nil {
+		return nil, err
+	}
+	res := make(Bitmap, n)
+	if _, err = io.ReadFull(r, res); err != nil {
+		return nil, truncated(err)
+	}
+	return res, nil
+}
+
+func (s *Snapshot) Write(w io.Writer) error {
+	var inlined [5]byte // inline buffer for variable length integers
+	buf := inlined[:]
+
+	if _, err := w.Write(s.author); err != nil {
+		return err
+	}
+	if _, err := w.Write(s.sign); err != nil {
+		return err
+	}
+	if err := WriteIDs(s.cut, w); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(buf, uint64(len(s.entries)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, r := range s.entries {
+		var err error
+		if r.Pruned() {
+			if _, err = w.Write([]byte{0}); err != nil {
+				return err
+			}
+			err = writeTombstone(w, r)
+		} else {
+			if _, err = w.Write([]byte{1}); err != nil {
+				return err
+			}
+			err = r.Write(w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	n = binary.PutUvarint(buf, uint64(len(s.state)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(s.state)
+	return err
+}
+
+// ReadSnapshot reads a Snapshot written by Snapshot.Write using DefaultLimits.
+func ReadSnapshot(r *bufio.Reader) (*Snapshot, error) {
+	return DefaultLimits.ReadSnapshot(r)
+}
+
+// ReadSnapshot reads a Snapshot written by Snapshot.Write. Snapshot signature is verified as well.
+func (l Limits) ReadSnapshot(r *bufio.Reader) (*Snapshot, error) {
+	s := &Snapshot{
+		author: make([]byte, ed25519.PublicKeySize),
+		sign:   make([]byte, ed25519.SignatureSize),
+	}
+	if _, err := io.ReadFull(r, s.author); err != nil {
+		return nil, truncated(err)
+	}
+	if _, err := io.ReadFull(r, s.sign); err != nil {
+		return nil, truncated(err)
+	}
+	var err error
+	if s.cut, err = readIDs(r, l.MaxIDs); err != nil {
+		return nil, truncated(err)
+	}
+	n, err := readLength(r, l.MaxEntries)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	s.entries = make([]*Record, n)
+	for i := range s.entries {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, truncated(err)
+		}
+		switch kind {
+		case 0:
+			if s.entries[i], err = l.readTombstone(r); err != nil {
+				return nil, truncated(err)
+			}
+		case 1:
+			if s.entries[i], err = l.ReadRecord(r); err != nil {
+				return nil, truncated(err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown snapshot entry type %d", kind)
+		}
+	}
+	sl, err := readLength(r, l.MaxStateSize)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	s.state = make([]byte, sl)
+	if _, err = io.ReadFull(r, s.state); err != nil {
+		return nil, truncated(err)
+	}
+	if err = s.Verify(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
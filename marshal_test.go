@@ -5,6 +5,13 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -44,3 +51,187 @@ func TestRecordsReadWrite(t *testing.T) {
 		t.Fatalf("deserialized content is different from the original at index 2")
 	}
 }
+
+// newLegacyRecord creates a Record in a legacy RecordV0 format.
+func newLegacyRecord(pub ed25519.PublicKey, priv ed25519.PrivateKey, deps []ID, data []byte) *Record {
+	r := &Record{
+		version: RecordV0,
+		author:  pub,
+		deps:    deps,
+		data:    data,
+	}
+	r.id = r.hash()
+	r.sign = ed25519.Sign(priv, data)
+	return r
+}
+
+func TestLegacyRecordRead(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := newLegacyRecord(pub, priv, []ID{}, []byte("A"))
+	b := NewRecord(pub, priv, []ID{a.id}, []byte("B"))
+
+	var buf bytes.Buffer
+	if err = WriteRecords([]*Record{a, b}, &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// legacy records are written in their original format: just after the records count
+	if !bytes.Equal(buf.Bytes()[1:1+ed25519.PublicKeySize], pub) {
+		t.Fatalf("legacy record should start with an author public key")
+	}
+	rs, err := ReadRecords(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rs[0].version != RecordV0 || !bytes.Equal(rs[0].id, a.id) {
+		t.Fatalf("legacy record was not read back")
+	}
+	if rs[1].version != LatestRecordVersion || !bytes.Equal(rs[1].id, b.id) {
+		t.Fatalf("record was not read back")
+	}
+}
+
+func TestSequencedRecordReadWrite(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := NewSequencedRecord(pub, priv, 1000, nil, []byte("A"))
+	var buf bytes.Buffer
+	if err = a.Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := ReadRecord(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if r.Seq() != 1000 || !bytes.Equal(r.id, a.id) {
+		t.Fatalf("sequenced record was not read back")
+	}
+	// sequence number is covered by the signature
+	forged := *a
+	forged.seq = 1
+	forged.id = forged.hash()
+	if err = forged.Verify(); !errors.Is(err, SignatureError) {
+		t.Fatalf("expected forged sequence number to be rejected, got: %v", err)
+	}
+}
+
+func TestRecordSignatureReplay(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := NewRecord(pub, priv, []ID{}, []byte("A"))
+	b := NewRecord(pub, priv, []ID{a.id}, []byte("B"))
+
+	// reuse signature of b in a record with the same data, but different dependencies
+	forged := &Record{version: b.version, author: b.author, sign: b.sign, deps: []ID{}, data: b.data}
+	forged.id = forged.hash()
+	if err = forged.Verify(); err == nil {
+		t.Fatalf("expected replayed signature to fail verification")
+	}
+
+	// the same attack works against legacy records, so they're not accepted by default
+	la := newLegacyRecord(pub, priv, []ID{}, []byte("A"))
+	lb := newLegacyRecord(pub, priv, []ID{la.id}, []byte("B"))
+	forged = &Record{version: RecordV0, author: lb.author, sign: lb.sign, deps: []ID{}, data: lb.data}
+	forged.id = forged.hash()
+	if err = forged.Verify(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, NewMemStore())
+	if err = p.Integrate([]*Record{forged}); err != LegacyRecordError {
+		t.Fatalf("expected legacy record to be rejected, got: %v", err)
+	}
+	p = NewPeer(pub, priv, NewMemStore(), AllowLegacyRecords())
+	if err = p.Integrate([]*Record{la, lb}); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestReadRecordTruncated(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := NewRecord(pub, priv, []ID{}, []byte("A"))
+	b := NewRecord(pub, priv, []ID{a.id}, []byte("B"))
+	var buf bytes.Buffer
+	if err = b.Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data := buf.Bytes()
+	if _, err = ReadRecord(bufio.NewReader(bytes.NewReader(nil))); err != io.EOF {
+		t.Fatalf("expected EOF on empty input, got: %v", err)
+	}
+	for i := 1; i < len(data); i++ {
+		r, err := ReadRecord(bufio.NewReader(bytes.NewReader(data[:i])))
+		if r != nil || !errors.Is(err, TruncatedError) {
+			t.Fatalf("expected input truncated at %d bytes to fail, got: %v", i, err)
+		}
+	}
+}
+
+func TestReadRecordLimits(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := NewRecord(pub, priv, []ID{}, []byte("A"))
+	b := NewRecord(pub, priv, []ID{a.id}, []byte("hello world"))
+	var buf bytes.Buffer
+	if err = b.Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	limits := Limits{MaxDataSize: 5}
+	if _, err = limits.ReadRecord(bufio.NewReader(bytes.NewReader(buf.Bytes()))); !errors.Is(err, LengthLimitError) {
+		t.Fatalf("expected data size limit to be exceeded, got: %v", err)
+	}
+	limits = Limits{MaxDeps: 0, MaxDataSize: 11}
+	if _, err = limits.ReadRecord(bufio.NewReader(bytes.NewReader(buf.Bytes()))); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// forged list length must not cause allocation of the declared size
+	var forged bytes.Buffer
+	forged.Write([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	if _, err = ReadIDs(bufio.NewReader(&forged)); !errors.Is(err, LengthLimitError) {
+		t.Fatalf("expected IDs count limit to be exceeded, got: %v", err)
+	}
+}
+
+func TestWriteIDsHashLength(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteIDs([]ID{make(ID, 16)}, &buf); !errors.Is(err, HashLengthError) {
+		t.Fatalf("expected invalid hash length, got: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("nothing should be written on error")
+	}
+}
+
+func TestReadIDsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteIDs([]ID{make(ID, sha256.Size)}, &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data := buf.Bytes()
+	_, err := ReadIDs(bufio.NewReader(bytes.NewReader(data[:len(data)-1])))
+	if !errors.Is(err, HashLengthError) || !errors.Is(err, TruncatedError) {
+		t.Fatalf("expected identifier cut short to have invalid length, got: %v", err)
+	}
+	if err = WriteIDs([]ID{make(ID, 31)}, &buf); !errors.Is(err, HashLengthError) {
+		t.Fatalf("expected identifier of invalid length not to be written, got: %v", err)
+	}
+
+	buf.Reset()
+	buf.Write(binary.AppendUvarint(nil, math.MaxInt32+1))
+	_, err = Limits{}.ReadIDs(bufio.NewReader(&buf))
+	if !errors.Is(err, LengthLimitError) || !strings.Contains(err.Error(), fmt.Sprint(math.MaxInt32)) {
+		t.Fatalf("expected length to exceed the effective limit, got: %v", err)
+	}
+}
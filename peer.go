@@ -3,32 +3,129 @@ package bec
 import (
 	"crypto/ed25519"
 	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
 )
 
+// LegacyRecordError happens when a Peer, which doesn't accept legacy records, tries to integrate one.
+var LegacyRecordError = fmt.Errorf("legacy record format is not accepted")
+
 const (
 	BloomBitsPerEntry = 10
 	BloomHashes       = 7
 )
 
+// Peer is safe for concurrent use. Operations modifying its state, like Commit and Integrate, are
+// serialized, while queries are served concurrently.
 type Peer struct {
-	pub         ed25519.PublicKey   // Peer's public key, equals to Author
-	priv        ed25519.PrivateKey  // Peer's private key, used for verification
-	heads       []ID                // the "youngest" (logically) records. All newly created records on this peer will refer to heads as their deps.
-	store       *MemStore           // Store where records are stored
-	stash       *Stash              // Stash used as a temporary container for records which are being resolved
-	missingDeps map[string]struct{} // "known" missing deps preventing records from stash to be integrated into store
+	mu       sync.RWMutex         // guards heads and serializes changes of the store
+	pub      ed25519.PublicKey    // Peer's public key, equals to Author
+	priv     ed25519.PrivateKey   // Peer's private key, used for verification
+	heads    []ID                 // the "youngest" (logically) records of all collections. Newly created records refer to the heads of their collection as their deps.
+	store    Store                // Store where records are stored
+	stash    *Stash               // Stash used as a temporary container for records which are being resolved
+	legacy   bool                 // if true, records in legacy RecordV0 format are accepted by Integrate
+	updated  chan struct{}        // closed and replaced whenever new records get committed, used to wake up subscriptions
+	acks     map[string][]ID      // heads acknowledged by known replicas, by their names
+	blocked  map[string]struct{}  // ids of dropped records, if records of equivocating authors are blocked
+	interest interest             // records replicated by this peer
+	keys     map[string][]byte    // collection keys shared with this peer, by their hex encoded ids
+	shares   map[string]*keyShare // latest key records shared with this peer, by collection names
+	owners   map[string]AuthorId  // configured collection owners, by collection names
+	acl      *aclIndex            // moderation operations of all collections
+	derived  derivedIndex         // indexes of optional Store interfaces, which the store doesn't implement
+	now      func() time.Time     // source of physical time of record timestamps
+	skew     time.Duration        // maximum time by which integrated records can be ahead of now
+	clock    Timestamp            // timestamp of the latest record created by this peer
+}
+
+// PeerOption configures an optional Peer behaviour.
+type PeerOption func(p *Peer)
+
+// AllowLegacyRecords makes Peer integrate records in a legacy RecordV0 format. Their signatures cover only
+// the record data, so they can be forged by replaying the same data with different dependencies.
+func AllowLegacyRecords() PeerOption {
+	return func(p *Peer) {
+		p.legacy = true
+	}
+}
+
+// WithStash bounds the Stash used to keep records with missing dependencies by a given config.
+// DefaultStashConfig is used otherwise.
+func WithStash(cfg StashConfig) PeerOption {
+	return func(p *Peer) {
+		p.stash = NewBoundedStash(cfg)
+	}
 }
 
 // NewPeer returns a peer instance representing current peer.
-func NewPeer(pub ed25519.PublicKey, priv ed25519.PrivateKey, store *MemStore) *Peer {
-	return &Peer{
-		pub:         pub,
-		priv:        priv,
-		heads:       store.Heads(),
-		store:       store,
-		missingDeps: make(map[string]struct{}),
-		stash:       NewStash(),
+func NewPeer(pub ed25519.PublicKey, priv ed25519.PrivateKey, store Store, opts ...PeerOption) *Peer {
+	p := &Peer{
+		pub:     pub,
+		priv:    priv,
+		heads:   store.Heads(),
+		store:   store,
+		stash:   NewBoundedStash(DefaultStashConfig),
+		updated: make(chan struct{}),
+		acks:    make(map[string][]ID),
+		keys:    make(map[string][]byte),
+		shares:  make(map[string]*keyShare),
+		now:     time.Now,
+		skew:    DefaultMaxClockSkew,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.acl = newACLIndex(p.owners)
+	p.derived = newDerivedIndex(store)
+	for _, r := range store.Since(0, store.Len()) {
+		p.index(r)
+	}
+	return p
+}
+
+// derivedIndex keeps the indexes Peer needs for stores, which don't provide them by implementing
+// CollectionStore or SeqStore.
+type derivedIndex struct {
+	collections map[string]struct{} // names of collections with committed records, nil for a CollectionStore
+	seqs        map[string]uint64   // latest sequence numbers by chainKey, nil for a SeqStore
+}
+
+func newDerivedIndex(store Store) derivedIndex {
+	var idx derivedIndex
+	if _, ok := store.(CollectionStore); !ok {
+		idx.collections = make(map[string]struct{})
+	}
+	if _, ok := store.(SeqStore); !ok {
+		idx.seqs = make(map[string]uint64)
 	}
+	return idx
+}
+
+func (idx derivedIndex) add(r *Record) {
+	if idx.collections != nil {
+		idx.collections[r.coll] = struct{}{}
+	}
+	if idx.seqs != nil && r.seq > idx.seqs[chainKey(r.author, r.coll)] {
+		idx.seqs[chainKey(r.author, r.coll)] = r.seq
+	}
+}
+
+// index updates indexes kept by the peer with a newly committed record. Caller must hold a write lock.
+func (p *Peer) index(r *Record) {
+	p.acl.add(r)
+	p.derived.add(r)
+	p.indexKey(r)
+}
+
+// lastSeq returns the sequence number of the latest record of a given author in a given collection.
+// Caller must hold at least a read lock.
+func (p *Peer) lastSeq(author AuthorId, collection string) uint64 {
+	if s, ok := p.store.(SeqStore); ok {
+		return s.LastSeq(author, collection)
+	}
+	return p.derived.seqs[chainKey(author, collection)]
 }
 
 func (p *Peer) Author() AuthorId {
@@ -36,82 +133,219 @@ func (p *Peer) Author() AuthorId {
 }
 
 func (p *Peer) Heads() []ID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.heads
 }
 
+// Commit creates a new record with given data in the default collection.
 func (p *Peer) Commit(data []byte) (*Record, error) {
-	c := NewRecord(p.pub, p.priv, p.heads, data)
+	return p.CommitTo("", data)
+}
+
+// next creates a new record of a given kind of this peer in a given collection, which follows its
+// previous records there. Caller must hold a write lock.
+func (p *Peer) next(collection string, kind byte, data []byte) *Record {
+	c := &Record{
+		version: LatestRecordVersion,
+		author:  p.pub,
+		deps:    p.headsOf(collection),
+		data:    data,
+		seq:     p.lastSeq(p.pub, collection) + 1,
+		time:    p.tick(),
+		coll:    collection,
+		kind:    kind,
+	}
+	return c.seal(p.priv)
+}
+
+// commit puts a Record created by current peer into the store. Caller must hold a write lock.
+func (p *Peer) commit(c *Record) error {
 	err := p.store.Commit(c)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// since newly created patch is the latest one dependent on
-	// the previous heads, it becomes the new head
-	p.heads = []ID{c.id}
-	return c, err
+	p.index(c)
+	// newly created patch replaces the heads of its collection, heads of other collections stay
+	p.heads = p.store.Heads()
+	p.notify()
+	return nil
+}
+
+// notify wakes up subscriptions waiting for new records. Caller must hold a write lock.
+func (p *Peer) notify() {
+	close(p.updated)
+	p.updated = make(chan struct{})
 }
 
 // Integrate records into current peer. Patches are expected to be listed in their causal order.
-// If Record has some unsatisfied dependencies, it will be stashed instead.
+// If Record has some unsatisfied dependencies, it will be stashed instead. Once all of them get
+// committed, stashed record is committed as well.
 func (p *Peer) Integrate(rs []*Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	changed := false
+	defer func() {
+		if changed {
+			p.heads = p.store.Heads()
+			p.notify()
+		}
+	}()
 	for _, r := range rs {
 		if err := r.Verify(); err != nil {
 			return err // remote patch was forged
 		}
-		if p.store.Contains(r.id) || p.stash.Contains(r.id) {
+		if r.version == RecordV0 && !p.legacy {
+			return LegacyRecordError
+		}
+		if err := p.checkSkew(r); err != nil {
+			return err
+		}
+		if p.store.Contains(r.id) || p.stash.Contains(r.id) || p.isBlocked(r.id) {
 			continue // already seen in either log or stash
 		}
+		if !p.interest.follows(r.coll) {
+			continue // collection is not replicated by this peer
+		}
+		if r.isSkeleton() && (r.interpreted() || p.interest.matches(r)) {
+			continue // data of the records this peer is interested in is required, it will be requested again
+		}
+		if r.isSkeleton() || !p.interest.matches(r) {
+			r = r.Tombstone() // only dependencies of the records this peer is interested in are kept
+		}
+		if p.block(r) {
+			continue // created by an equivocating author or depends on such record
+		}
 
 		// check if dependencies are satisfied
 		var missingDeps []ID
 		for _, dep := range r.deps {
 			if !p.store.Contains(dep) {
-				if !p.stash.Contains(dep) {
-					missingDeps = append(missingDeps, dep)
-				}
+				missingDeps = append(missingDeps, dep)
 			}
 		}
 
 		if len(missingDeps) > 0 {
-			p.stash.Add(r)
-			for _, d := range missingDeps {
-				p.missingDeps[hex.EncodeToString(d)] = struct{}{}
-			}
+			// dependencies which are already stashed will be resolved once they get committed
+			p.stash.Add(r, missingDeps)
 		} else {
-			if err := p.store.Commit(r); err != nil {
+			changed = true
+			if err := p.integrate(r); err != nil {
 				return err
 			}
-			delete(p.missingDeps, hex.EncodeToString(r.id))
-			changed = true
 		}
 	}
-	if changed {
-		p.heads = p.store.Heads()
-		// try to reintegrate stashed elements
-		rs = p.stash.UnStash()
-		if len(rs) != 0 {
-			return p.Integrate(rs) // can we hope for tail recursion here?
-		}
+	return nil
+}
+
+// integrate commits a record, which has all of its dependencies committed, followed by the stashed
+// records waiting for it. Caller must hold a write lock.
+func (p *Peer) integrate(r *Record) error {
+	admitted, err := p.admit(r)
+	if err != nil || !admitted {
+		return err
 	}
+	p.release(p.stash.Release(r.id))
 	return nil
 }
 
+// release commits records released from the stash, followed by the stashed records waiting for them.
+// Released records have not necessarily been sent by whoever sent the record releasing them, so the
+// ones, which cannot be committed, are dropped without reporting an error. Records waiting for them
+// stay stashed until they expire. Caller must hold a write lock.
+func (p *Peer) release(queue []*Record) {
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		admitted, err := p.admit(r)
+		if err != nil {
+			p.stash.reject()
+			continue
+		}
+		if admitted {
+			queue = append(queue, p.stash.Release(r.id)...)
+		}
+	}
+}
+
+// admit checks and commits a record, which has all of its dependencies committed. Returns false if
+// the record has been blocked instead. Caller must hold a write lock.
+func (p *Peer) admit(r *Record) (bool, error) {
+	if p.block(r) {
+		return false, nil
+	}
+	if err := p.checkTime(r); err != nil {
+		return false, err
+	}
+	if err := p.checkACL(r); err != nil {
+		return false, err // moderation record created without permission
+	}
+	if err := p.store.Commit(r); err != nil {
+		return false, err
+	}
+	p.index(r)
+	return true, nil
+}
+
 // MissingDeps returns a list of known missing records that prevent applying records from stash to be put into the store.
 func (p *Peer) MissingDeps() []ID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	var res []ID
-	for dep := range p.missingDeps {
-		pid, _ := hex.DecodeString(dep)
-		res = append(res, pid)
+	for _, id := range p.stash.MissingDeps() {
+		if !p.isBlocked(id) {
+			res = append(res, id)
+		}
 	}
 	return res
 }
 
+// StashStats returns statistics of records stashed while waiting for their dependencies.
+func (p *Peer) StashStats() StashStats {
+	return p.stash.Stats()
+}
+
+// Announce returns heads of collections replicated by this peer.
 func (p *Peer) Announce() []ID {
-	if p.stash == nil {
-		p.stash = NewStash()
+	return p.announceTo(nil)
+}
+
+// BloomAnnounce returns heads of replicated collections together with a Bloom filter of all records, which are not
+// predecessors of `since`. `since` are the heads known to be shared with a remote peer at the time
+// of the last synchronization, so that the filter only needs to cover records added after it.
+func (p *Peer) BloomAnnounce(since []ID) ([]ID, Bitmap) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rs := p.store.Missing(since)
+	filter := NewBloom(len(rs))
+	for _, r := range rs {
+		filter.AddBloom(r.id, BloomHashes)
 	}
-	return p.heads
+	return p.announceLocked(nil), filter
+}
+
+// BloomMissing returns records, which a remote peer is likely missing, given its heads and a Bloom filter
+// produced by BloomAnnounce. These are all records, which are neither predecessors of remote heads nor
+// present in the filter, together with all of their successors: since remote peer doesn't have a record,
+// it cannot have any of its successors either, even if Bloom filter returned false positive for them.
+// Records are returned in their causal order.
+func (p *Peer) BloomMissing(heads []ID, filter Bitmap) []*Record {
+	var res []*Record
+	included := make(map[string]struct{})
+	for _, r := range p.store.Missing(heads) {
+		include := !filter.HasBloom(r.id, BloomHashes)
+		for _, dep := range r.deps {
+			if include {
+				break
+			}
+			_, include = included[hex.EncodeToString(dep)]
+		}
+		if include {
+			included[hex.EncodeToString(r.id)] = struct{}{}
+			res = append(res, r)
+		}
+	}
+	return res
 }
 
 func (p *Peer) Request(ids []ID) []*Record {
@@ -120,65 +354,60 @@ func (p *Peer) Request(ids []ID) []*Record {
 
 // NotFound filters out incoming ids, returning the ones from input slice that have not been found in current Peer.
 func (p *Peer) NotFound(ids []ID) []ID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	var res []ID
 	for _, id := range ids {
-		if !p.store.Contains(id) && !p.stash.Contains(id) {
+		if !p.store.Contains(id) && !p.stash.Contains(id) && !p.isBlocked(id) {
 			res = append(res, id)
 		}
 	}
 	return res
 }
 
-// Grant moderation permission over a collection with given name to a provided mod.
+// Grant moderation permission over a collection with given name to a provided mod. Only the owner of
+// the collection and its moderators can grant permissions, see WithOwner and OwnedCollection.
 func (p *Peer) Grant(name string, mod AuthorId) error {
-	panic("todo")
+	return p.moderate(aclGrant, name, mod)
 }
 
 // Revoke moderation permission over a collection with given name from a provided mod.
+// Collection owner's permissions cannot be revoked.
 func (p *Peer) Revoke(name string, mod AuthorId) error {
-	panic("todo")
+	return p.moderate(aclRevoke, name, mod)
 }
 
-const (
-	MsgAnnounce = iota
-	MsgRequest
-	MsgRecords
-)
-
-type PeerController struct {
-	in   chan []byte
-	out  map[string]chan<- []byte
-	peer *Peer
-}
-
-func NewController(p *Peer) *PeerController {
-	return &PeerController{
-		in:   make(chan []byte),
-		out:  make(map[string]chan<- []byte),
-		peer: p,
-	}
-}
-
-func (c *PeerController) Process() error {
-	for {
-		select {
-		case msg := <-c.in:
-			switch msg[0] {
-			case MsgAnnounce:
-				// heads []ID := parse(msg)
-				// ids := peer.NotFound(heads)
-				// sender <- MsgRequest(ids)
-			case MsgRequest:
-				// ids []ID := parse(msg)
-				// records := peer.store.GetMany(pids)
-				// sender <- MsgRecords(records)
-			case MsgRecords:
-				// records []*Record := parse(msg)
-				// peer.Integrate(records)
-				// ids := peer.MissingDeps()
-				// if len(ids) > 0:
-				//     sender <- MsgRequest(ids)
-			}
-		}
+func (p *Peer) moderate(op byte, name string, mod AuthorId) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.next(name, kindACL, encodeACL(op, mod))
+	if err := p.checkACL(c); err != nil {
+		return err
 	}
+	return p.commit(c)
+}
+
+// checkACL verifies that a moderation or key record has been created by an author with moderation
+// permissions at that point. Other records are always accepted.
+func (p *Peer) checkACL(r *Record) error {
+	return p.acl.check(r)
+}
+
+// Moderators returns all authors having moderation permissions over a collection with given name,
+// starting with the collection owner. Moderation records, which were issued by a moderator
+// concurrently to revoking it, are not taken into account.
+func (p *Peer) Moderators(name string) []AuthorId {
+	p.mu.Lock() // evaluation memoizes its results
+	defer p.mu.Unlock()
+	return p.acl.view(name).moderators()
+}
+
+// Rejected returns identifiers of moderation and key records of a collection with given name, which have
+// been rejected because their author had no moderation permissions at the time. This includes
+// records issued concurrently to revoking their author. Given the same set of records, result is
+// the same on every peer.
+func (p *Peer) Rejected(name string) []ID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.acl.view(name).rejected()
 }
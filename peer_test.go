@@ -1,8 +1,11 @@
 package bec
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/hex"
+	"sync"
 	"testing"
 )
 
@@ -10,6 +13,140 @@ func TestReconcileV1(t *testing.T) {
 	testReconcile(t, reconcileV1)
 }
 
+func TestReconcileBloom(t *testing.T) {
+	testReconcile(t, reconcileBloom)
+}
+
+func TestBloomFilter(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	records := testRecords(pub, priv)
+	filter := NewBloom(len(records))
+	for _, r := range records[:3] {
+		filter.AddBloom(r.id, BloomHashes)
+	}
+	for _, r := range records[:3] {
+		if !filter.HasBloom(r.id, BloomHashes) {
+			t.Fatalf("bloom filter is missing added record %s", hex.EncodeToString(r.id))
+		}
+	}
+}
+
+func TestPeerConcurrent(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		testPeerConcurrent(t)
+	})
+	t.Run("blocking", func(t *testing.T) {
+		testPeerConcurrent(t, BlockEquivocators())
+	})
+}
+
+func testPeerConcurrent(t *testing.T, opts ...PeerOption) {
+	const remotes, commits, n = 4, 4, 50
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, NewMemStore(), opts...)
+	chains := make([][]*Record, remotes)
+	for i := range chains {
+		remote := newTestPeer(t)
+		for j := 0; j < n; j++ {
+			r, err := remote.Commit([]byte{byte(j)})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			chains[i] = append(chains[i], r)
+		}
+	}
+
+	// records of an equivocating author, which get blocked, if the peer blocks equivocators
+	evil := newTestPeer(t)
+	root := NewRecord(evil.pub, evil.priv, nil, []byte("root"))
+	forks := []*Record{root}
+	for j := 0; j < n; j++ {
+		forks = append(forks, NewRecord(evil.pub, evil.priv, []ID{root.id}, []byte{byte(j)}))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, remotes+commits+1)
+	done := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := range forks {
+			if err := p.Integrate(forks[i : i+1]); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				p.NotFound([]ID{forks[len(forks)-1].id})
+			}
+		}
+	}()
+	for _, chain := range chains {
+		wg.Add(1)
+		go func(chain []*Record) {
+			defer wg.Done()
+			// integrate in reverse order, so that records go through the stash
+			for i := len(chain) - 1; i >= 0; i-- {
+				if err := p.Integrate(chain[i : i+1]); err != nil {
+					errs <- err
+					return
+				}
+				heads, filter := p.BloomAnnounce(nil)
+				p.BloomMissing(heads, filter)
+				p.Request(p.NotFound(heads))
+				p.MissingDeps()
+			}
+		}(chain)
+	}
+	for i := 0; i < commits; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if _, err := p.Commit([]byte{byte(i), byte(j)}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent operation failed: %s", err.Error())
+	}
+
+	if p.stash.Len() != 0 {
+		t.Fatalf("expected all records to be unstashed, %d left", p.stash.Len())
+	}
+	honest := 0
+	for _, r := range p.store.Missing(nil) {
+		if !bytes.Equal(r.author, evil.pub) {
+			honest++
+		}
+	}
+	if honest != (remotes+commits)*n {
+		t.Fatalf("expected %d records, found %d", (remotes+commits)*n, honest)
+	}
+	if !sameIDs(p.Heads(), p.store.Heads()) {
+		t.Fatalf("peer heads differ from store heads")
+	}
+}
+
 func testReconcile(t *testing.T, reconcile func(src *Peer, dst *Peer) error) {
 	pub1, priv1, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
@@ -49,14 +186,14 @@ func testReconcile(t *testing.T, reconcile func(src *Peer, dst *Peer) error) {
 	compareStores(p1.store, p2.store, t)
 }
 
-func compareStores(s1 *MemStore, s2 *MemStore, t *testing.T) {
+func compareStores(s1 Store, s2 Store, t *testing.T) {
 	k1 := make(map[string]struct{})
-	for k := range s1.index {
-		k1[k] = struct{}{}
+	for _, r := range s1.Missing(nil) {
+		k1[hex.EncodeToString(r.id)] = struct{}{}
 	}
 	k2 := make(map[string]struct{})
-	for k := range s2.index {
-		k2[k] = struct{}{}
+	for _, r := range s2.Missing(nil) {
+		k2[hex.EncodeToString(r.id)] = struct{}{}
 	}
 	if len(k1) != len(k2) {
 		t.Fatal("stores have different size")
@@ -86,3 +223,38 @@ func reconcileV1(src *Peer, dst *Peer) error {
 	}
 	return nil
 }
+
+func reconcileBloom(src *Peer, dst *Peer) error {
+	heads, filter := dst.BloomAnnounce(nil)        // B sends its heads and a Bloom filter of its records
+	records := src.BloomMissing(heads, filter)     // A replies with everything B is likely missing
+	if err := dst.Integrate(records); err != nil { // B integrates A's records
+		return err
+	}
+	// Bloom filter false positives are resolved by explicit requests: false positive on a head means
+	// it has never been sent, so heads of A still unknown to B are requested as well
+	missing := append(dst.MissingDeps(), dst.NotFound(src.Heads())...)
+	for len(missing) > 0 {
+		if err := dst.Integrate(src.Request(missing)); err != nil {
+			return err
+		}
+		missing = append(dst.MissingDeps(), dst.NotFound(src.Heads())...)
+	}
+	return nil
+}
+
+func TestPeerCommitSequence(t *testing.T) {
+	p := newTestPeer(t)
+	for i := uint64(1); i <= 3; i++ {
+		r, err := p.Commit([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if r.Seq() != i {
+			t.Fatalf("expected sequence number %d, got %d", i, r.Seq())
+		}
+	}
+	ms := p.store.(*MemStore)
+	if res := ms.ByAuthor(p.pub, "", ms.LastSeq(p.pub, ""), 1); len(res) != 1 || res[0].data[0] != 3 {
+		t.Fatalf("expected the latest record of the peer")
+	}
+}
@@ -4,10 +4,57 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 )
 
+const (
+	// RecordV0 is a legacy record format. Its signature covers only the record data, so that
+	// it can be replayed by anyone as part of another record with the same data.
+	RecordV0 byte = 0
+	// RecordV1 is a record format, which signature covers a domain separated hash of all record fields.
+	RecordV1 byte = 1
+	// RecordV2 is a record format, which additionally carries an optional per-author sequence number.
+	RecordV2 byte = 2
+	// RecordV3 is a record format, which additionally carries an optional hybrid logical clock timestamp.
+	RecordV3 byte = 3
+	// RecordV4 is a record format, which additionally carries a name of the collection it belongs to.
+	RecordV4 byte = 4
+	// RecordV5 is a record format, which additionally carries a kind telling user data apart from
+	// records interpreted by peers themselves, like moderation records.
+	RecordV5 byte = 5
+	// RecordV6 is a record format, which hash covers a SHA256 digest of the record data instead of
+	// the data itself, so that records can be verified without their data.
+	RecordV6 byte = 6
+
+	// LatestRecordVersion is the format version used by newly created records.
+	LatestRecordVersion = RecordV6
+)
+
+// Kinds of records. Since kind is covered by the record signature, user data cannot be mistaken
+// for records interpreted by peers, no matter what it contains.
+const (
+	kindData      byte = iota // user data
+	kindACL                   // moderation operation, see Peer.Grant and Peer.Revoke
+	kindKey                   // collection key share, see Peer.ShareKey
+	kindEncrypted             // user data encrypted by a collection key, see Peer.CommitEncrypted
+)
+
+// recordDomain is a domain separation tag of record hashes, which are signed since RecordV1.
+const recordDomain = "bec/record"
+
+var (
+	// UnsupportedVersionError happens when verifying a Record in a format newer than LatestRecordVersion.
+	UnsupportedVersionError = fmt.Errorf("unsupported record version")
+
+	// HashMismatchError happens when Record id is not a hash of its contents.
+	HashMismatchError = fmt.Errorf("record hash and id don't match")
+
+	// SignatureError happens when Record signature was not made by its author.
+	SignatureError = fmt.Errorf("record signature verification failed")
+)
+
 // ID is a unique Record identifier. Generated as a consistent hash of that Record contents.
 type ID = []byte
 
@@ -15,41 +62,202 @@ type ID = []byte
 type AuthorId = ed25519.PublicKey
 
 type Record struct {
-	id     ID       // globally unique content addressed SHA256 hash of current Record
-	author AuthorId // creator of current Record
-	sign   []byte   // signature used by an author used for Record verification
-	deps   []ID     // dependencies: hashes of direct predecessors of this Record
-	data   []byte   // user data
+	version byte      // record format version
+	id      ID        // globally unique content addressed SHA256 hash of current Record
+	author  AuthorId  // creator of current Record
+	sign    []byte    // signature used by an author used for Record verification
+	deps    []ID      // dependencies: hashes of direct predecessors of this Record
+	data    []byte    // user data
+	seq     uint64    // position of the Record in a chain of its author's records starting at 1, 0 if not sequenced
+	time    Timestamp // time of the Record creation, zero if not timestamped
+	coll    string    // name of the collection the Record belongs to, empty for the default one
+	kind    byte      // kind of the Record, kindData for all records created before RecordV5
+	digest  []byte    // SHA256 digest of the data, set only for skeletons, which don't carry the data itself
 }
 
 func NewRecord(pub ed25519.PublicKey, priv ed25519.PrivateKey, deps []ID, data []byte) *Record {
+	return NewSequencedRecord(pub, priv, 0, deps, data)
+}
+
+// NewSequencedRecord creates a Record with a given per-author sequence number. Author's first record
+// has sequence number 1 and every next one increments it, so that gaps in author's chain of records
+// can be detected. Sequence number 0 means that Record is not sequenced.
+func NewSequencedRecord(pub ed25519.PublicKey, priv ed25519.PrivateKey, seq uint64, deps []ID, data []byte) *Record {
 	p := &Record{
-		data:   data,
-		deps:   deps,
-		author: pub,
+		version: LatestRecordVersion,
+		data:    data,
+		deps:    deps,
+		author:  pub,
+		seq:     seq,
+	}
+	return p.seal(priv)
+}
+
+// seal computes the id of a Record and signs it with its author's private key.
+func (r *Record) seal(priv ed25519.PrivateKey) *Record {
+	r.id = r.hash()
+	r.sign = ed25519.Sign(priv, r.signed())
+	return r
+}
+
+// Version returns the format version of the Record.
+func (r *Record) Version() byte {
+	return r.version
+}
+
+// ID returns the content addressed identifier of the Record.
+func (r *Record) ID() ID {
+	return r.id
+}
+
+// Author returns the public key of the Record creator.
+func (r *Record) Author() AuthorId {
+	return r.author
+}
+
+// Deps returns identifiers of direct predecessors of the Record.
+func (r *Record) Deps() []ID {
+	return r.deps
+}
+
+// Data returns user data carried by the Record.
+func (r *Record) Data() []byte {
+	return r.data
+}
+
+// Seq returns the per-author sequence number of the Record, or 0 if it's not sequenced.
+func (r *Record) Seq() uint64 {
+	return r.seq
+}
+
+// Timestamp returns the hybrid logical clock timestamp of the Record creation, or zero Timestamp
+// if it's not timestamped.
+func (r *Record) Timestamp() Timestamp {
+	return r.time
+}
+
+// Collection returns the name of the collection the Record belongs to. Records of the default
+// collection, including the ones created before collections were introduced, return an empty name.
+func (r *Record) Collection() string {
+	return r.coll
+}
+
+// Tombstone returns a stripped down copy of the Record, which keeps only its identifier, dependencies,
+// author, sequence number and collection. Tombstones take place of pruned records, so that they can
+// still be referred to as dependencies and chains of author's records have no gaps.
+func (r *Record) Tombstone() *Record {
+	return &Record{version: r.version, id: r.id, deps: r.deps, author: r.author, seq: r.seq, coll: r.coll}
+}
+
+// skeleton returns a copy of the Record without its data, which can still be verified, since its hash
+// covers only the data digest. Only records since RecordV6 have skeletons.
+func (r *Record) skeleton() *Record {
+	s := *r
+	s.digest = r.dataDigest()
+	s.data = nil
+	return &s
+}
+
+// isSkeleton checks if the Record is a skeleton of a record, which data was left out.
+func (r *Record) isSkeleton() bool {
+	return r.digest != nil
+}
+
+// interpreted checks if the Record is interpreted by peers themselves, rather than carrying user data.
+func (r *Record) interpreted() bool {
+	return r.kind == kindACL || r.kind == kindKey
+}
+
+// dataDigest returns the SHA256 digest of the Record data, which is covered by its hash since RecordV6.
+func (r *Record) dataDigest() []byte {
+	if r.digest != nil {
+		return r.digest
 	}
-	p.id = p.hash()
-	p.sign = ed25519.Sign(priv, p.data) // could we just sign p.id? It's probably smaller and unique as well.
-	return p
+	d := sha256.Sum256(r.data)
+	return d[:]
+}
+
+// Pruned checks if the Record is a tombstone of a pruned record.
+func (r *Record) Pruned() bool {
+	return r.sign == nil
 }
 
 func (r *Record) Verify() error {
+	if r.version > LatestRecordVersion {
+		return fmt.Errorf("%w %d: %s", UnsupportedVersionError, r.version, hex.EncodeToString(r.id))
+	}
+	if r.isSkeleton() && r.version < RecordV6 {
+		return fmt.Errorf("%w %d without data: %s", UnsupportedVersionError, r.version, hex.EncodeToString(r.id))
+	}
 	if bytes.Compare(r.hash(), r.id) != 0 {
-		return fmt.Errorf("record hash and id don't match: %s", hex.EncodeToString(r.id))
+		return fmt.Errorf("%w: %s", HashMismatchError, hex.EncodeToString(r.id))
 	}
-	if !ed25519.Verify(r.author, r.data, r.sign) {
-		return fmt.Errorf("record signature verficiation failed: %s", hex.EncodeToString(r.id))
+	if !ed25519.Verify(r.author, r.signed(), r.sign) {
+		return fmt.Errorf("%w: %s", SignatureError, hex.EncodeToString(r.id))
 	}
 	return nil
 }
 
+// signed returns the message covered by the Record signature.
+func (r *Record) signed() []byte {
+	if r.version == RecordV0 {
+		return r.data
+	}
+	return r.id
+}
+
 // Returns a content addressable hash of a given Record.
 func (r *Record) hash() ID {
 	h := sha256.New()
+	if r.version == RecordV0 {
+		for _, d := range r.deps {
+			h.Write(d)
+		}
+		h.Write(r.data)
+		h.Write(r.author)
+		return h.Sum(nil)
+	}
+	// all variable length fields are prefixed with their length, so that
+	// no two different records can produce the same input to the hash function
+	var inlined [binary.MaxVarintLen64]byte
+	buf := inlined[:]
+	h.Write([]byte(recordDomain))
+	h.Write([]byte{r.version})
+	h.Write(r.author)
+	if r.version >= RecordV2 {
+		n := binary.PutUvarint(buf, r.seq)
+		h.Write(buf[:n])
+	}
+	if r.version >= RecordV3 {
+		n := binary.PutUvarint(buf, r.time.Wall)
+		h.Write(buf[:n])
+		n = binary.PutUvarint(buf, uint64(r.time.Logical))
+		h.Write(buf[:n])
+	}
+	if r.version >= RecordV4 {
+		n := binary.PutUvarint(buf, uint64(len(r.coll)))
+		h.Write(buf[:n])
+		h.Write([]byte(r.coll))
+	}
+	if r.version >= RecordV5 {
+		h.Write([]byte{r.kind})
+	}
+	n := binary.PutUvarint(buf, uint64(len(r.deps)))
+	h.Write(buf[:n])
 	for _, d := range r.deps {
 		h.Write(d)
 	}
+	if r.version >= RecordV6 {
+		h.Write(r.dataDigest())
+		return h.Sum(nil)
+	}
+	n = binary.PutUvarint(buf, uint64(len(r.data)))
+	h.Write(buf[:n])
 	h.Write(r.data)
-	h.Write(r.author)
 	return h.Sum(nil)
 }
+
+// size returns an approximate number of bytes occupied by a Record.
+func (r *Record) size() int {
+	return len(r.author) + len(r.sign) + len(r.deps)*sha256.Size + len(r.data)
+}
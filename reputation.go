@@ -0,0 +1,205 @@
+package bec
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BannedError is returned when connecting a remote peer, which has been banned for misbehaviour.
+var BannedError = fmt.Errorf("remote peer is banned")
+
+// maxRequested is the maximum number of record ids remembered as requested from a single remote.
+const maxRequested = 1 << 16
+
+// ReputationConfig describes how PeerController penalizes misbehaving remote peers. Every offense
+// adds a penalty to the score of a remote. Zero thresholds mean no limit.
+type ReputationConfig struct {
+	InvalidPenalty     int           // penalty for a record with invalid hash or signature, or an unauthorized moderation record
+	MalformedPenalty   int           // penalty for a message or frame, which could not be decoded
+	UnsolicitedPenalty int           // penalty for every record, which was neither requested nor sent in reply to sync
+	DisconnectScore    int           // remote is disconnected once the penalties of its current connection reach this score
+	BanScore           int           // remote is banned once the penalties of all its connections reach this score
+	BanDuration        time.Duration // time for which a banned remote cannot connect
+}
+
+// DefaultReputationConfig disconnects remotes sending invalid data right away and bans the ones,
+// which keep doing so after reconnecting.
+var DefaultReputationConfig = ReputationConfig{
+	InvalidPenalty:     100,
+	MalformedPenalty:   100,
+	UnsolicitedPenalty: 1,
+	DisconnectScore:    100,
+	BanScore:           1000,
+	BanDuration:        time.Hour,
+}
+
+// WithReputation sets the ReputationConfig used to penalize misbehaving remotes.
+// DefaultReputationConfig is used otherwise.
+func WithReputation(cfg ReputationConfig) ControllerOption {
+	return func(c *PeerController) {
+		c.reputation = cfg
+	}
+}
+
+// Reputation describes the misbehaviour of a remote peer over its current connection.
+type Reputation struct {
+	InvalidSignatures int // records with invalid signatures
+	HashMismatches    int // records, which id doesn't match their contents
+	Unauthorized      int // moderation records created without moderation permissions
+	Malformed         int // messages and frames, which could not be decoded
+	Unsolicited       int // records, which were neither requested nor sent in reply to sync
+	Score             int // sum of penalties
+}
+
+type offense int
+
+const (
+	offenseSignature offense = iota
+	offenseHash
+	offenseUnauthorized
+	offenseMalformed
+	offenseUnsolicited
+)
+
+// classify returns an offense committed by a remote, which caused a given error. Returns false
+// if the error was not caused by the remote.
+func classify(err error) (offense, bool) {
+	switch {
+	case errors.Is(err, SignatureError):
+		return offenseSignature, true
+	case errors.Is(err, HashMismatchError):
+		return offenseHash, true
+	case errors.Is(err, UnauthorizedError):
+		return offenseUnauthorized, true
+	case errors.Is(err, MalformedMessageError),
+		errors.Is(err, TruncatedError),
+		errors.Is(err, LengthLimitError),
+		errors.Is(err, HashLengthError),
+		errors.Is(err, UnsupportedVersionError),
+		errors.Is(err, LegacyRecordError),
+		errors.Is(err, FrameTooLargeError):
+		return offenseMalformed, true
+	}
+	return 0, false
+}
+
+func (cfg *ReputationConfig) penalty(o offense) int {
+	switch o {
+	case offenseMalformed:
+		return cfg.MalformedPenalty
+	case offenseUnsolicited:
+		return cfg.UnsolicitedPenalty
+	default:
+		return cfg.InvalidPenalty
+	}
+}
+
+func (rep *Reputation) count(o offense, n int) {
+	switch o {
+	case offenseSignature:
+		rep.InvalidSignatures += n
+	case offenseHash:
+		rep.HashMismatches += n
+	case offenseUnauthorized:
+		rep.Unauthorized += n
+	case offenseMalformed:
+		rep.Malformed += n
+	case offenseUnsolicited:
+		rep.Unsolicited += n
+	}
+}
+
+// Reputation returns the misbehaviour of a connected remote with a given name.
+func (c *PeerController) Reputation(name string) (Reputation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.out[name]
+	if !ok {
+		return Reputation{}, false
+	}
+	return r.rep, true
+}
+
+// Banned checks if a remote with a given name is currently banned.
+func (c *PeerController) Banned(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.banned(name)
+}
+
+// banned checks if a remote with a given name is banned. Caller must hold c.mu.
+func (c *PeerController) banned(name string) bool {
+	h, ok := c.health[name]
+	return ok && c.now().Before(h.banned)
+}
+
+// misbehaved handles an error caused by a message received from a remote. Remote is penalized if
+// it has committed an offense, otherwise it's disconnected.
+func (c *PeerController) misbehaved(name string, err error) {
+	c.fail(name)
+	if o, ok := classify(err); ok {
+		c.penalize(name, o, 1)
+	} else {
+		c.Disconnect(name)
+	}
+}
+
+// penalize adds a penalty for n offenses to the score of a remote with a given name, disconnecting
+// or banning it once it exceeds configured thresholds.
+func (c *PeerController) penalize(name string, o offense, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cfg := &c.reputation
+	penalty := cfg.penalty(o) * n
+	h := c.healthOf(name)
+	h.score += penalty
+	r, connected := c.out[name]
+	if connected {
+		r.rep.count(o, n)
+		r.rep.Score += penalty
+	}
+	if cfg.BanScore > 0 && h.score >= cfg.BanScore {
+		h.banned = c.now().Add(cfg.BanDuration)
+		h.score = 0 // once the ban is over, remote gets another chance
+		c.disconnect(name)
+	} else if connected && cfg.DisconnectScore > 0 && r.rep.Score >= cfg.DisconnectScore {
+		c.disconnect(name)
+	}
+}
+
+// expect remembers records requested from a remote. Caller must own r, i.e. run within Process loop.
+func (r *remote) expect(ids []ID) {
+	if len(r.requested) > maxRequested {
+		r.requested = make(map[string]struct{}) // remote doesn't reply, there's no point remembering everything
+	}
+	for _, id := range ids {
+		r.requested[hex.EncodeToString(id)] = struct{}{}
+	}
+}
+
+// unsolicited returns the number of received records, which remote sent without being asked for.
+// If more is set, records are a part of a reply, which continues in the next message.
+// Caller must own r, i.e. run within Process loop.
+func (r *remote) unsolicited(records []*Record, more bool) int {
+	rest := 0
+	for _, rec := range records {
+		key := hex.EncodeToString(rec.id)
+		if _, ok := r.requested[key]; ok {
+			delete(r.requested, key)
+		} else {
+			rest++
+		}
+	}
+	if rest > 0 || len(records) == 0 {
+		// only replies to sync can contain records we didn't ask for explicitly, or no records at all
+		if r.syncs > 0 {
+			if !more {
+				r.syncs-- // sync is replied once the last part of the reply arrives
+			}
+			return 0
+		}
+	}
+	return rest
+}
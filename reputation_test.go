@@ -0,0 +1,107 @@
+package bec
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// eventually waits until a given condition is met.
+func eventually(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting until %s", msg)
+}
+
+// forged returns a copy of a record with tampered data, which no longer matches its signature.
+func forged(r *Record) *Record {
+	f := *r
+	f.data = append([]byte("forged "), r.data...)
+	return &f
+}
+
+func TestRecordVerifyErrors(t *testing.T) {
+	p := newTestPeer(t)
+	r := NewRecord(p.pub, p.priv, nil, []byte("A"))
+	f := forged(r)
+	if err := f.Verify(); !errors.Is(err, HashMismatchError) {
+		t.Fatalf("expected hash mismatch, got: %v", err)
+	}
+	f.id = f.hash()
+	if err := f.Verify(); !errors.Is(err, SignatureError) {
+		t.Fatalf("expected invalid signature, got: %v", err)
+	}
+	if err := p.Integrate([]*Record{f}); !errors.Is(err, SignatureError) {
+		t.Fatalf("expected integration to fail on invalid signature, got: %v", err)
+	}
+}
+
+func TestReputationBan(t *testing.T) {
+	cfg := ReputationConfig{InvalidPenalty: 100, DisconnectScore: 250, BanScore: 300, BanDuration: time.Minute}
+	c := NewController(newTestPeer(t), WithReputation(cfg))
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	go c.Process()
+	defer c.Close()
+
+	in := make(chan []byte)
+	if err := c.Connect("evil", in, make(chan []byte, 16)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	evil := newTestPeer(t)
+	r := NewRecord(evil.pub, evil.priv, nil, []byte("A"))
+	f := forged(r)
+	f.id = f.hash()
+	for i := 1; i <= 2; i++ {
+		in <- encodeRecords(MsgRecords, []*Record{f})
+		eventually(t, "invalid record is penalized", func() bool {
+			rep, _ := c.Reputation("evil")
+			return rep.InvalidSignatures == i
+		})
+	}
+	if rep, ok := c.Reputation("evil"); !ok || rep.Score != 200 {
+		t.Fatalf("expected remote to stay connected below threshold, got: %+v", rep)
+	}
+	in <- encodeRecords(MsgRecords, []*Record{f})
+	eventually(t, "remote is banned", func() bool {
+		return c.Banned("evil") && len(c.Remotes()) == 0
+	})
+	if err := c.Connect("evil", make(chan []byte), make(chan []byte, 16)); err != BannedError {
+		t.Fatalf("expected banned remote not to connect, got: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := c.Connect("evil", make(chan []byte), make(chan []byte, 16)); err != nil {
+		t.Fatalf("expected remote to connect after ban, got: %v", err)
+	}
+}
+
+func TestReputationUnsolicited(t *testing.T) {
+	c := NewController(newTestPeer(t))
+	go c.Process()
+	defer c.Close()
+
+	in := make(chan []byte)
+	out := make(chan []byte, 16)
+	if err := c.Connect("remote", in, out); err != nil {
+		t.Fatalf(err.Error())
+	}
+	<-out // wait for the sync message sent on connect
+
+	remote := newTestPeer(t)
+	records := testRecords(remote.pub, remote.priv)
+	// reply to sync is expected to carry records we didn't ask for explicitly
+	in <- encodeRecords(MsgRecords, records[:2])
+	in <- encodeRecords(MsgRecords, records[2:5])
+	eventually(t, "unsolicited records are penalized", func() bool {
+		rep, _ := c.Reputation("remote")
+		return rep.Unsolicited == 3
+	})
+	if rep, _ := c.Reputation("remote"); rep.Score != 3*DefaultReputationConfig.UnsolicitedPenalty {
+		t.Fatalf("unexpected score: %+v", rep)
+	}
+}
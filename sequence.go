@@ -0,0 +1,141 @@
+package bec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+var (
+	// SequenceGapError happens when a sequenced Record is committed before the previous record of its author.
+	SequenceGapError = fmt.Errorf("record sequence number skips previous records of its author")
+
+	// SequenceOrderError happens when a sequenced Record doesn't have a higher sequence number than the
+	// records of its author it depends on.
+	SequenceOrderError = fmt.Errorf("record sequence number doesn't follow previous records of its author")
+)
+
+// chainKey returns a key of records created by a given author in a given collection. Collections are
+// replicated independently, so every one of them has its own chain of author's records.
+func chainKey(author AuthorId, collection string) string {
+	return hex.EncodeToString(author) + "/" + collection
+}
+
+// chain is a sequence of records created by a single author in a single collection, ordered by their sequence numbers.
+// It starts at the first sequenced record of the author known to the store. Pruned records are
+// part of the chain as well, since their tombstones keep their sequence numbers.
+type chain struct {
+	first uint64 // sequence number of the first record in the chain
+	pos   []int  // log positions of records, record at pos[i] has sequence number first+i
+}
+
+// last returns the sequence number of the last record in the chain.
+func (c *chain) last() uint64 {
+	return c.first + uint64(len(c.pos)) - 1
+}
+
+// checkSeq makes sure that a sequenced record directly follows the latest sequenced record of its
+// author among its predecessors, or that it's the first record of its author with sequence number 1.
+// Caller must hold at least a read lock.
+func (ms *MemStore) checkSeq(p *Record) error {
+	if p.seq == 0 {
+		return nil
+	}
+	prev := ms.prevSeq(p)
+	if p.seq > prev+1 {
+		return fmt.Errorf("%w: %s has sequence number %d, expected %d",
+			SequenceGapError, hex.EncodeToString(p.id), p.seq, prev+1)
+	}
+	if p.seq <= prev {
+		return fmt.Errorf("%w: %s has sequence number %d, expected %d",
+			SequenceOrderError, hex.EncodeToString(p.id), p.seq, prev+1)
+	}
+	return nil
+}
+
+// prevSeq returns the highest sequence number of the records of the author of a given record in
+// its collection, which precede it, or 0 if there are none. Since every committed sequenced record
+// has passed checkSeq, its sequence number is higher than the ones of its author's predecessors,
+// so they don't need to be visited. Tombstones keep sequence numbers of pruned records, so they
+// are taken into account as well. Caller must hold at least a read lock.
+func (ms *MemStore) prevSeq(p *Record) uint64 {
+	c, ok := ms.chains[chainKey(p.author, p.coll)]
+	if !ok {
+		return 0 // author has no sequenced records in the collection
+	}
+	first := c.pos[0] // records committed before the first one of the author cannot precede any of them
+	var prev uint64
+	visited := make(map[int]struct{})
+	stack := ms.indexes(p.deps)
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[i]; ok || i < first {
+			continue
+		}
+		visited[i] = struct{}{}
+		r := ms.log[i]
+		if r.seq > 0 && r.coll == p.coll && bytes.Equal(r.author, p.author) {
+			if r.seq > prev {
+				prev = r.seq
+			}
+			continue
+		}
+		stack = append(stack, ms.indexes(r.deps)...)
+	}
+	return prev
+}
+
+// chainSeq adds a record at a given log position to the chain of its author. Records reusing
+// sequence numbers already taken are not part of the chain, they are equivocations detected by
+// detect. Caller must hold a write lock.
+func (ms *MemStore) chainSeq(i int) {
+	p := ms.log[i]
+	if p.seq == 0 {
+		return
+	}
+	key := chainKey(p.author, p.coll)
+	c, ok := ms.chains[key]
+	if !ok {
+		ms.chains[key] = &chain{first: p.seq, pos: []int{i}}
+	} else if p.seq == c.last()+1 {
+		c.pos = append(c.pos, i)
+	}
+}
+
+// LastSeq returns the sequence number of the latest record of a given author in a given collection,
+// or 0 if no sequenced records of that author have been committed there.
+func (ms *MemStore) LastSeq(author AuthorId, collection string) uint64 {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	c, ok := ms.chains[chainKey(author, collection)]
+	if !ok {
+		return 0
+	}
+	return c.last()
+}
+
+// ByAuthor returns up to `take` records of a given author in a given collection starting at sequence
+// number `seq`, in their sequence order. Pruned records are skipped.
+func (ms *MemStore) ByAuthor(author AuthorId, collection string, seq uint64, take int) []*Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	c, ok := ms.chains[chainKey(author, collection)]
+	if !ok || seq > c.last() {
+		return nil
+	}
+	start := 0
+	if seq > c.first {
+		start = int(seq - c.first)
+	}
+	var res []*Record
+	for _, i := range c.pos[start:] {
+		if len(res) == take {
+			break
+		}
+		if r := ms.log[i]; !r.Pruned() {
+			res = append(res, r)
+		}
+	}
+	return res
+}
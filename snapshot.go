@@ -0,0 +1,206 @@
+package bec
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+var (
+	// UnknownCutError happens when a Snapshot is taken or applied at a cut, which has not been committed.
+	UnknownCutError = fmt.Errorf("snapshot cut has not been committed")
+
+	// SnapshotSignatureError happens when Snapshot signature doesn't match its contents.
+	SnapshotSignatureError = fmt.Errorf("snapshot signature verification failed")
+
+	// PruningUnsupportedError happens when a Snapshot with pruned records is applied to a Peer,
+	// whose store is not a PrunableStore.
+	PruningUnsupportedError = fmt.Errorf("store doesn't support pruning")
+)
+
+// snapshotDomain is a domain separation tag of snapshot hashes.
+const snapshotDomain = "bec/snapshot"
+
+// Snapshot is an application state at a given cut of the DAG, signed by its author. The cut is
+// described by its heads: snapshot covers them and all of their predecessors, which can be pruned
+// once the snapshot is taken. Since a Snapshot replaces the records it covers, the cut should be
+// causally stable, i.e. all peers should have received all of the covered records already.
+//
+// Snapshot carries the skeleton of the covered part of the DAG: tombstones of its records, so that
+// peers bootstrapping from the snapshot can resolve them as dependencies of the records following
+// the cut. Moderation and key records are never pruned, as they are needed to evaluate moderation
+// permissions and to decrypt records.
+type Snapshot struct {
+	cut     []ID      // heads of the cut
+	entries []*Record // tombstones, moderation and key records covered by the snapshot, in causal order
+	state   []byte    // application state at the cut
+	author  AuthorId  // creator of the snapshot
+	sign    []byte    // signature of the snapshot hash
+}
+
+// Cut returns heads of the cut, at which the Snapshot was taken.
+func (s *Snapshot) Cut() []ID {
+	return s.cut
+}
+
+// State returns the application state at the cut.
+func (s *Snapshot) State() []byte {
+	return s.state
+}
+
+// Author returns the public key of the Snapshot creator.
+func (s *Snapshot) Author() AuthorId {
+	return s.author
+}
+
+// Verify checks if the Snapshot has been signed by its author. It's up to the application to decide
+// whether the author is trusted to produce snapshots.
+func (s *Snapshot) Verify() error {
+	if len(s.author) != ed25519.PublicKeySize || !ed25519.Verify(s.author, s.hash(), s.sign) {
+		return SnapshotSignatureError
+	}
+	return nil
+}
+
+// hash returns a domain separated hash of all Snapshot fields other than its signature.
+func (s *Snapshot) hash() []byte {
+	var inlined [binary.MaxVarintLen64]byte
+	buf := inlined[:]
+	h := sha256.New()
+	writeIDs := func(ids []ID) {
+		n := binary.PutUvarint(buf, uint64(len(ids)))
+		h.Write(buf[:n])
+		for _, id := range ids {
+			h.Write(id)
+		}
+	}
+	h.Write([]byte(snapshotDomain))
+	h.Write(s.author)
+	writeIDs(s.cut)
+	n := binary.PutUvarint(buf, uint64(len(s.entries)))
+	h.Write(buf[:n])
+	for _, r := range s.entries {
+		// id of a record already covers all of its fields
+		if r.Pruned() {
+			h.Write([]byte{0})
+		} else {
+			h.Write([]byte{1})
+		}
+		h.Write(r.id)
+		writeIDs(r.deps)
+		if r.Pruned() {
+			n := binary.PutUvarint(buf, uint64(len(r.author)))
+			h.Write(buf[:n])
+			h.Write(r.author)
+			n = binary.PutUvarint(buf, r.seq)
+			h.Write(buf[:n])
+			n = binary.PutUvarint(buf, uint64(len(r.coll)))
+			h.Write(buf[:n])
+			h.Write([]byte(r.coll))
+		}
+	}
+	n = binary.PutUvarint(buf, uint64(len(s.state)))
+	h.Write(buf[:n])
+	h.Write(s.state)
+	return h.Sum(nil)
+}
+
+// Snapshot creates a signed Snapshot of a given application state at a cut described by its heads.
+func (p *Peer) Snapshot(cut []ID, state []byte) (*Snapshot, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, id := range cut {
+		if !p.store.Contains(id) {
+			return nil, UnknownCutError
+		}
+	}
+	log := p.store.Since(0, p.store.Len())
+	covered := make(map[string]struct{})
+	for _, id := range cut {
+		covered[hex.EncodeToString(id)] = struct{}{}
+	}
+	for i := len(log) - 1; i >= 0; i-- {
+		if _, ok := covered[hex.EncodeToString(log[i].id)]; ok {
+			for _, dep := range log[i].deps {
+				covered[hex.EncodeToString(dep)] = struct{}{}
+			}
+		}
+	}
+	s := &Snapshot{cut: cut, state: state, author: p.pub}
+	for _, r := range log {
+		if _, ok := covered[hex.EncodeToString(r.id)]; !ok {
+			continue
+		}
+		if _, ok := decodeModeration(r); !ok && !r.Pruned() {
+			r = r.Tombstone()
+		}
+		s.entries = append(s.entries, r)
+	}
+	s.sign = ed25519.Sign(p.priv, s.hash())
+	return s, nil
+}
+
+// Prune replaces all records covered by a given Snapshot with their tombstones. All records of
+// the snapshot cut must have been committed before, and the store must be a PrunableStore.
+func (p *Peer) Prune(s *Snapshot) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := s.Verify(); err != nil {
+		return err
+	}
+	for _, id := range s.cut {
+		if !p.store.Contains(id) {
+			return UnknownCutError
+		}
+	}
+	return p.restore(s)
+}
+
+// Bootstrap initializes the Peer from a given Snapshot, so that it can integrate records following
+// the snapshot cut without having the records covered by it. Application is expected to restore its
+// state from Snapshot.State.
+func (p *Peer) Bootstrap(s *Snapshot) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := s.Verify(); err != nil {
+		return err
+	}
+	return p.restore(s)
+}
+
+// restore makes the store contain entries of a Snapshot: records, which are already committed, are
+// pruned, while the missing ones are committed. Caller must hold a write lock.
+func (p *Peer) restore(s *Snapshot) error {
+	defer func() {
+		p.heads = p.store.Heads()
+		p.notify()
+	}()
+	var pruned []ID
+	var released []*Record
+	for _, r := range s.entries {
+		if p.store.Contains(r.id) {
+			if r.Pruned() {
+				pruned = append(pruned, r.id)
+			}
+			continue
+		}
+		if err := p.store.Commit(r); err != nil {
+			return err
+		}
+		p.index(r)
+		released = append(released, p.stash.Release(r.id)...)
+	}
+	if len(pruned) > 0 {
+		ps, ok := p.store.(PrunableStore)
+		if !ok {
+			return PruningUnsupportedError
+		}
+		if err := ps.Prune(pruned); err != nil {
+			return err
+		}
+	}
+	p.release(released)
+	return nil
+}
@@ -0,0 +1,89 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestSnapshotBootstrap(t *testing.T) {
+	a := newTestPeer(t)
+	mod := newTestPeer(t)
+	c := OwnedCollection(a.pub, "c")
+	if err := a.Grant(c, mod.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, data := range []string{"A", "B", "C"} {
+		if _, err := a.Commit([]byte(data)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	s, err := a.Snapshot(a.Heads(), []byte("ABC"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = a.Prune(s); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if res := a.store.Missing(nil); len(res) != 1 {
+		t.Fatalf("expected only moderation record to survive pruning, found %d records", len(res))
+	}
+	if !sameAuthors(a.Moderators(c), []AuthorId{a.pub, mod.pub}) {
+		t.Fatalf("moderators changed after pruning")
+	}
+	x, err := a.Commit([]byte("X"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err = s.Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	decoded, err := ReadSnapshot(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %s", err.Error())
+	}
+	if !bytes.Equal(decoded.State(), []byte("ABC")) || !sameIDs(decoded.Cut(), s.Cut()) {
+		t.Fatalf("decoded snapshot doesn't match the original")
+	}
+
+	b := newTestPeer(t)
+	if err = b.Bootstrap(decoded); err != nil {
+		t.Fatalf("failed to bootstrap: %s", err.Error())
+	}
+	if !sameIDs(b.Heads(), s.Cut()) {
+		t.Fatalf("expected bootstrapped peer heads to match the snapshot cut")
+	}
+	exchange(t, a, b)
+	if b.store.Get(x.id) == nil || !sameIDs(a.Heads(), b.Heads()) {
+		t.Fatalf("bootstrapped peer didn't receive records following the cut")
+	}
+	if !sameAuthors(b.Moderators(c), a.Moderators(c)) {
+		t.Fatalf("bootstrapped peer doesn't know moderators")
+	}
+	compareStores(a.store, b.store, t)
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	a := newTestPeer(t)
+	r, err := a.Commit([]byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	other := newTestPeer(t)
+	if _, err = other.Snapshot([]ID{r.id}, nil); err != UnknownCutError {
+		t.Fatalf("expected snapshot at unknown cut to fail, got: %v", err)
+	}
+	s, err := a.Snapshot([]ID{r.id}, []byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = other.Prune(s); err != UnknownCutError {
+		t.Fatalf("expected pruning at unknown cut to fail, got: %v", err)
+	}
+	s.state = []byte("B")
+	if err = other.Bootstrap(s); err != SnapshotSignatureError {
+		t.Fatalf("expected tampered snapshot to be rejected, got: %v", err)
+	}
+}
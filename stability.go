@@ -0,0 +1,89 @@
+package bec
+
+import "encoding/hex"
+
+// Acknowledge records heads announced by a replica with a given name. Since replicas announce only
+// heads of records they already have, all of their predecessors are known to have been seen by it.
+// Replica is tracked until it's forgotten, even if it's disconnected in the meantime.
+func (p *Peer) Acknowledge(replica string, heads []ID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.acks[replica] = heads
+}
+
+// track starts tracking a replica with a given name, unless it's already tracked. Until replica
+// acknowledges any heads, no records are stable.
+func (p *Peer) track(replica string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.acks[replica]; !ok {
+		p.acks[replica] = nil
+	}
+}
+
+// Forget stops tracking a replica with a given name, i.e. when it has left for good and it no
+// longer needs to receive records, which are about to be pruned.
+func (p *Peer) Forget(replica string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.acks, replica)
+}
+
+// Replicas returns names of all tracked replicas.
+func (p *Peer) Replicas() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make([]string, 0, len(p.acks))
+	for name := range p.acks {
+		res = append(res, name)
+	}
+	return res
+}
+
+// Stable returns causally stable records in their causal order. These are the records, which are
+// predecessors of heads acknowledged by every tracked replica, so they are known to have been seen
+// by all of them. If no replicas are tracked, all records are stable.
+func (p *Peer) Stable() []*Record {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.stable()
+}
+
+// StableHeads returns heads of causally stable records, which make a cut suitable for a Snapshot.
+func (p *Peer) StableHeads() []ID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rs := p.stable()
+	inner := make(map[string]struct{}, len(rs))
+	for _, r := range rs {
+		for _, dep := range r.deps {
+			inner[hex.EncodeToString(dep)] = struct{}{}
+		}
+	}
+	var res []ID
+	for _, r := range rs {
+		if _, ok := inner[hex.EncodeToString(r.id)]; !ok {
+			res = append(res, r.id)
+		}
+	}
+	return res
+}
+
+// stable returns causally stable records. Caller must hold at least a read lock.
+func (p *Peer) stable() []*Record {
+	// acknowledged heads, which are not known locally, are skipped, which only
+	// makes the result more conservative
+	seen := make(map[string]int)
+	for _, heads := range p.acks {
+		for _, r := range p.store.Predecessors(heads) {
+			seen[hex.EncodeToString(r.id)]++
+		}
+	}
+	var res []*Record
+	for _, r := range p.store.Missing(nil) {
+		if seen[hex.EncodeToString(r.id)] == len(p.acks) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
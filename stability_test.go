@@ -0,0 +1,73 @@
+package bec
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStable(t *testing.T) {
+	a := newTestPeer(t)
+	b := newTestPeer(t)
+	c := newTestPeer(t)
+	r1, err := a.Commit([]byte("A1"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r2, err := a.Commit([]byte("A2"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !sameRecords(a.Stable(), []*Record{r1, r2}) {
+		t.Fatalf("expected all records to be stable without replicas")
+	}
+
+	a.Acknowledge("b", nil)
+	a.Acknowledge("c", nil)
+	if len(a.Stable()) != 0 {
+		t.Fatalf("expected no stable records before replicas acknowledge any")
+	}
+	if err = b.Integrate([]*Record{r1, r2}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	a.Acknowledge("b", b.Announce())
+	if len(a.Stable()) != 0 {
+		t.Fatalf("expected no stable records before all replicas acknowledge them")
+	}
+	if err = c.Integrate([]*Record{r1}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	a.Acknowledge("c", c.Announce())
+	if !sameRecords(a.Stable(), []*Record{r1}) || !sameIDs(a.StableHeads(), []ID{r1.id}) {
+		t.Fatalf("expected only record seen by all replicas to be stable")
+	}
+
+	a.Forget("c")
+	if !sameRecords(a.Stable(), []*Record{r1, r2}) || !sameIDs(a.StableHeads(), []ID{r2.id}) {
+		t.Fatalf("expected forgotten replica not to be taken into account")
+	}
+}
+
+func TestControllerAcknowledges(t *testing.T) {
+	p1 := newTestPeer(t)
+	p2 := newTestPeer(t)
+	c1 := NewController(p1)
+	c2 := NewController(p2)
+	go c1.Process()
+	go c2.Process()
+	defer c1.Close()
+	defer c2.Close()
+	link(t, c1, "p1", c2, "p2")
+
+	r, err := c1.Commit([]byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sameIDs(p1.StableHeads(), []ID{r.id}) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("record didn't become stable in time")
+}
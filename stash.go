@@ -0,0 +1,258 @@
+package bec
+
+import (
+	"container/list"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// StashConfig bounds the resources used by a Stash. Zero values mean no limit.
+type StashConfig struct {
+	MaxCount     int           // maximum number of stashed records
+	MaxBytes     int           // maximum total size of stashed records
+	MaxPerAuthor int           // maximum number of stashed records created by the same author
+	MaxAge       time.Duration // maximum time a record can wait in the stash for its dependencies
+}
+
+// DefaultStashConfig is a StashConfig used by peers, unless configured otherwise.
+var DefaultStashConfig = StashConfig{
+	MaxCount:     1 << 16,
+	MaxBytes:     64 << 20,
+	MaxPerAuthor: 1 << 12,
+	MaxAge:       10 * time.Minute,
+}
+
+// StashStats describes what happened to the records put into a Stash.
+type StashStats struct {
+	Stashed  uint64 // number of records added to the stash
+	Evicted  uint64 // number of records evicted to satisfy count, size or per-author limits
+	Expired  uint64 // number of records evicted, because they were waiting for their dependencies for too long
+	Rejected uint64 // number of released records, which could not be committed
+}
+
+type stashEntry struct {
+	record    *Record
+	added     time.Time     // time when record was stashed
+	missing   []ID          // dependencies of the record which were not committed at the time of stashing
+	remaining int           // number of missing dependencies, which have not been released yet
+	elem      *list.Element // position in Stash.order
+	byOwner   *list.Element // position in Stash.authors list of record's author
+}
+
+// Stash is a temporary container for records which cannot be committed yet, because some of their
+// dependencies are missing. Since a Byzantine peer can send an endless stream of records with
+// fabricated dependencies, stash size is bounded: once the limits are exceeded, the oldest records
+// are evicted. Stash is safe for concurrent use.
+type Stash struct {
+	mu      sync.Mutex
+	cfg     StashConfig
+	now     func() time.Time
+	order   *list.List               // stashed entries, from the oldest to the newest
+	index   map[string]*stashEntry   // entries by their record id
+	authors map[string]*list.List    // stashed entries of each author, from the oldest to the newest
+	waiting map[string][]*stashEntry // entries waiting for a missing dependency with a given id
+	bytes   int                      // total size of stashed records
+	stats   StashStats
+}
+
+// NewStash returns a new Stash without any limits.
+func NewStash() *Stash {
+	return NewBoundedStash(StashConfig{})
+}
+
+// NewBoundedStash returns a new Stash, which size is bounded by a given config.
+func NewBoundedStash(cfg StashConfig) *Stash {
+	return &Stash{
+		cfg:     cfg,
+		now:     time.Now,
+		order:   list.New(),
+		index:   make(map[string]*stashEntry),
+		authors: make(map[string]*list.List),
+		waiting: make(map[string][]*stashEntry),
+	}
+}
+
+// Add puts a record into the stash, together with its dependencies, which have not been committed yet.
+// If adding a record exceeds stash limits, the oldest records are evicted.
+func (s *Stash) Add(p *Record, missing []ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	id := hex.EncodeToString(p.id)
+	if _, found := s.index[id]; found {
+		return
+	}
+	author := hex.EncodeToString(p.author)
+	owned, ok := s.authors[author]
+	if !ok {
+		owned = list.New()
+		s.authors[author] = owned
+	}
+	e := &stashEntry{
+		record:    p,
+		added:     s.now(),
+		missing:   missing,
+		remaining: len(missing),
+	}
+	for _, dep := range missing {
+		key := hex.EncodeToString(dep)
+		s.waiting[key] = append(s.waiting[key], e)
+	}
+	e.elem = s.order.PushBack(e)
+	e.byOwner = owned.PushBack(e)
+	s.index[id] = e
+	s.bytes += p.size()
+	s.stats.Stashed++
+
+	if s.cfg.MaxPerAuthor > 0 {
+		for owned.Len() > s.cfg.MaxPerAuthor {
+			s.remove(owned.Front().Value.(*stashEntry))
+			s.stats.Evicted++
+		}
+	}
+	for (s.cfg.MaxCount > 0 && s.order.Len() > s.cfg.MaxCount) || (s.cfg.MaxBytes > 0 && s.bytes > s.cfg.MaxBytes) {
+		s.remove(s.order.Front().Value.(*stashEntry))
+		s.stats.Evicted++
+	}
+}
+
+// Expire evicts all records, which were waiting in the stash for longer than configured MaxAge.
+func (s *Stash) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+}
+
+func (s *Stash) expire() {
+	if s.cfg.MaxAge <= 0 {
+		return
+	}
+	deadline := s.now().Add(-s.cfg.MaxAge)
+	for s.order.Len() > 0 {
+		e := s.order.Front().Value.(*stashEntry)
+		if !e.added.Before(deadline) {
+			break
+		}
+		s.remove(e)
+		s.stats.Expired++
+	}
+}
+
+func (s *Stash) remove(e *stashEntry) {
+	author := hex.EncodeToString(e.record.author)
+	owned := s.authors[author]
+	owned.Remove(e.byOwner)
+	if owned.Len() == 0 {
+		delete(s.authors, author)
+	}
+	s.order.Remove(e.elem)
+	delete(s.index, hex.EncodeToString(e.record.id))
+	s.bytes -= e.record.size()
+	for _, dep := range e.missing {
+		key := hex.EncodeToString(dep)
+		waiting := s.waiting[key]
+		for i, w := range waiting {
+			if w == e {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(s.waiting, key)
+		} else {
+			s.waiting[key] = waiting
+		}
+	}
+}
+
+// Release notifies the stash that a record with given id has been committed. Stashed records, which
+// were waiting only for that record, are removed from the stash and returned in the order they were
+// stashed, so that they can be committed as well.
+func (s *Stash) Release(id ID) []*Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := hex.EncodeToString(id)
+	waiting, ok := s.waiting[key]
+	if !ok {
+		return nil
+	}
+	delete(s.waiting, key)
+	var res []*Record
+	for _, e := range waiting {
+		e.remaining--
+		if e.remaining == 0 {
+			s.remove(e)
+			res = append(res, e.record)
+		}
+	}
+	return res
+}
+
+// reject counts a released record, which could not be committed.
+func (s *Stash) reject() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Rejected++
+}
+
+func (s *Stash) Contains(id ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.index[hex.EncodeToString(id)]
+	return ok
+}
+
+// Len returns the number of stashed records.
+func (s *Stash) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// Stats returns statistics of records added and evicted from the stash.
+func (s *Stash) Stats() StashStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// MissingDeps returns dependencies of stashed records, which are neither committed nor stashed.
+// Dependencies of records which have been evicted are no longer reported.
+func (s *Stash) MissingDeps() []ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	var res []ID
+	seen := make(map[string]struct{})
+	for el := s.order.Front(); el != nil; el = el.Next() {
+		for _, dep := range el.Value.(*stashEntry).missing {
+			key := hex.EncodeToString(dep)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			_, waiting := s.waiting[key] // not waiting anymore once released
+			if _, stashed := s.index[key]; waiting && !stashed {
+				res = append(res, dep)
+			}
+		}
+	}
+	return res
+}
+
+// UnStash returns all records in the order they were stashed and clears up current Stash.
+func (s *Stash) UnStash() []*Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Record, 0, s.order.Len())
+	for el := s.order.Front(); el != nil; el = el.Next() {
+		res = append(res, el.Value.(*stashEntry).record)
+	}
+	s.order.Init()
+	s.index = make(map[string]*stashEntry)
+	s.authors = make(map[string]*list.List)
+	s.waiting = make(map[string][]*stashEntry)
+	s.bytes = 0
+	return res
+}
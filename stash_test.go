@@ -0,0 +1,207 @@
+package bec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// orphans returns records of a given author, each depending on a different missing record.
+func orphans(t *testing.T, n int) []*Record {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	res := make([]*Record, n)
+	for i := range res {
+		missing := NewRecord(pub, priv, nil, []byte{byte(i)})
+		res[i] = NewRecord(pub, priv, []ID{missing.id}, []byte("orphan"))
+	}
+	return res
+}
+
+func TestStashMaxCount(t *testing.T) {
+	s := NewBoundedStash(StashConfig{MaxCount: 2})
+	rs := orphans(t, 3)
+	for _, r := range rs {
+		s.Add(r, r.deps)
+	}
+	if s.Len() != 2 || s.Contains(rs[0].id) {
+		t.Fatalf("expected the oldest record to be evicted")
+	}
+	if s.Stats().Evicted != 1 || s.Stats().Stashed != 3 {
+		t.Fatalf("unexpected stats: %+v", s.Stats())
+	}
+	// dependencies of evicted records are no longer reported as missing
+	missing := s.MissingDeps()
+	if len(missing) != 2 || !sameIDs(missing, []ID{rs[1].deps[0], rs[2].deps[0]}) {
+		t.Fatalf("unexpected missing dependencies")
+	}
+}
+
+func TestStashMaxPerAuthor(t *testing.T) {
+	s := NewBoundedStash(StashConfig{MaxPerAuthor: 2})
+	spam := orphans(t, 3)
+	honest := orphans(t, 1)
+	s.Add(honest[0], honest[0].deps)
+	for _, r := range spam {
+		s.Add(r, r.deps)
+	}
+	if !s.Contains(honest[0].id) {
+		t.Fatalf("records of other authors should not be evicted")
+	}
+	if s.Contains(spam[0].id) || !s.Contains(spam[1].id) || !s.Contains(spam[2].id) {
+		t.Fatalf("expected the oldest record of an author to be evicted")
+	}
+}
+
+func TestStashMaxBytes(t *testing.T) {
+	rs := orphans(t, 3)
+	s := NewBoundedStash(StashConfig{MaxBytes: 2 * rs[0].size()})
+	for _, r := range rs {
+		s.Add(r, r.deps)
+	}
+	if s.Len() != 2 || s.Contains(rs[0].id) {
+		t.Fatalf("expected the oldest record to be evicted")
+	}
+}
+
+func TestStashExpiry(t *testing.T) {
+	now := time.Now()
+	s := NewBoundedStash(StashConfig{MaxAge: time.Minute})
+	s.now = func() time.Time { return now }
+	rs := orphans(t, 2)
+	s.Add(rs[0], rs[0].deps)
+	now = now.Add(30 * time.Second)
+	s.Add(rs[1], rs[1].deps)
+	now = now.Add(31 * time.Second)
+
+	missing := s.MissingDeps()
+	if len(missing) != 1 || !bytes.Equal(missing[0], rs[1].deps[0]) {
+		t.Fatalf("expected dependencies of expired record to be dropped")
+	}
+	if s.Contains(rs[0].id) || s.Stats().Expired != 1 {
+		t.Fatalf("expected record to expire")
+	}
+}
+
+func TestPeerStashBounded(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p := NewPeer(pub, priv, NewMemStore(), WithStash(StashConfig{MaxCount: 10}))
+	rs := orphans(t, 20)
+	if err := p.Integrate(rs); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if p.StashStats().Evicted != 10 || len(p.MissingDeps()) != 10 {
+		t.Fatalf("unexpected stash state: %+v", p.StashStats())
+	}
+}
+
+func TestIntegrateReversedChain(t *testing.T) {
+	p := newTestPeer(t)
+	const n = 2000
+	chain := make([]*Record, n)
+	var deps []ID
+	for i := range chain {
+		chain[i] = NewRecord(p.pub, p.priv, deps, []byte{byte(i)})
+		deps = []ID{chain[i].id}
+	}
+	for i := n - 1; i >= 0; i-- {
+		if err := p.Integrate(chain[i : i+1]); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if p.stash.Len() != 0 || len(p.MissingDeps()) != 0 {
+		t.Fatalf("expected all records to be unstashed")
+	}
+	if len(p.store.Missing(nil)) != n || !sameIDs(p.Heads(), []ID{chain[n-1].id}) {
+		t.Fatalf("expected all records to be committed")
+	}
+}
+
+func TestStashRelease(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	records := testRecords(pub, priv)
+	s := NewStash()
+	// E depends on B and C, F depends on E
+	s.Add(records[4], []ID{records[1].id, records[2].id})
+	s.Add(records[5], []ID{records[4].id})
+	if res := s.Release(records[1].id); len(res) != 0 {
+		t.Fatalf("record should wait for all of its dependencies")
+	}
+	if missing := s.MissingDeps(); len(missing) != 1 || !bytes.Equal(missing[0], records[2].id) {
+		t.Fatalf("released dependency should not be reported as missing")
+	}
+	if res := s.Release(records[2].id); len(res) != 1 || res[0] != records[4] {
+		t.Fatalf("expected E to be released")
+	}
+	if res := s.Release(records[4].id); len(res) != 1 || res[0] != records[5] {
+		t.Fatalf("expected F to be released")
+	}
+	if s.Len() != 0 {
+		t.Fatalf("expected stash to be empty")
+	}
+}
+
+func TestUnStash(t *testing.T) {
+	s := NewStash()
+	rs := orphans(t, 3)
+	for _, r := range rs {
+		s.Add(r, r.deps)
+	}
+	res := s.UnStash()
+	if len(res) != 3 || res[0] != rs[0] || res[1] != rs[1] || res[2] != rs[2] {
+		t.Fatalf("expected records in the order they were stashed")
+	}
+	if s.Len() != 0 || len(s.MissingDeps()) != 0 || len(s.Release(rs[0].deps[0])) != 0 {
+		t.Fatalf("expected stash to be cleared")
+	}
+	s.Add(rs[0], rs[0].deps)
+	if !s.Contains(rs[0].id) {
+		t.Fatalf("expected cleared stash to be usable")
+	}
+}
+
+func TestStashReleaseRejected(t *testing.T) {
+	owner, mod, p := newTestPeer(t), newTestPeer(t), newTestPeer(t)
+	c := OwnedCollection(owner.pub, "c")
+	if err := owner.Grant(c, owner.pub); err != nil {
+		t.Fatalf(err.Error())
+	}
+	exchange(t, owner, mod)
+	exchange(t, owner, p)
+	x, err := owner.CommitTo(c, []byte("X"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err = mod.Integrate([]*Record{x}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	forged := moderation(mod, aclGrant, c, mod.pub)
+	y, err := mod.CommitTo(c, []byte("Y"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if err = p.Integrate([]*Record{forged, y}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// record releasing an unauthorized one is not blamed for it
+	if err = p.Integrate([]*Record{x}); err != nil {
+		t.Fatalf("expected released records not to fail integration, got: %v", err)
+	}
+	if !p.store.Contains(y.id) || p.store.Contains(forged.id) {
+		t.Fatalf("expected only the unauthorized record to be dropped")
+	}
+	if stats := p.StashStats(); stats.Rejected != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -1,8 +1,11 @@
 package bec
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sort"
+	"sync"
 )
 
 var (
@@ -11,12 +14,103 @@ var (
 
 	// DependencyNotFoundError happens when Record was committed to the store which was missing one or more of its dependencies.
 	DependencyNotFoundError = fmt.Errorf("parent record not found")
+
+	// CollectionMismatchError happens when Record depends on records of another collection.
+	CollectionMismatchError = fmt.Errorf("record depends on records of another collection")
+)
+
+// Store is a log of records forming a causal DAG. Every record can be committed only
+// once all of its dependencies have been committed, which means that the log order
+// is always a valid causal order.
+//
+// Tombstones of records pruned elsewhere can be committed as well (see Record.Tombstone). They are
+// committed as far as Contains and dependencies of other records are concerned, but they are not
+// returned by any of the other methods, unless stated otherwise.
+//
+// Stores can provide additional indexes by implementing optional interfaces: PrunableStore,
+// CollectionStore, SeqStore and EquivocationStore. Peer derives the ones it needs by itself otherwise.
+type Store interface {
+	// Get returns a Record identified by provided id. Returns nil if no Record with given id was found.
+	Get(id ID) *Record
+	// GetMany returns a slice of records matching provided sequence of ids, omitting the ones that were not found.
+	GetMany(ids []ID) []*Record
+	// Contains checks if Record with a given id has been committed.
+	Contains(id ID) bool
+	// Commit appends a Record to the store. All of its dependencies must have been committed before.
+	Commit(r *Record) error
+	// Heads returns identifiers of records, which have no successors.
+	Heads() []ID
+	// Predecessors returns records of given heads and all of their predecessors. Their order is not
+	// a topological one, use IterateBackward for that.
+	Predecessors(heads []ID) []*Record
+	// Missing returns records, which are not predecessors of given heads, in their causal order.
+	Missing(heads []ID) []*Record
+	// LatestN returns the `take` latest committed records, skipping the `skip` most recent of them.
+	LatestN(skip int, take int) []*Record
+	// Since returns up to `take` records committed at log position `pos` or later, in their commit order.
+	// Tombstones are returned as well, so that positions of all records are known.
+	Since(pos int, take int) []*Record
+	// Len returns the number of committed records, which is also the log position of the next one.
+	Len() int
+}
+
+// PrunableStore is a Store, which can replace committed records with their tombstones.
+type PrunableStore interface {
+	Store
+	// Prune replaces committed records with given ids with their tombstones. Unknown ids are ignored.
+	Prune(ids []ID) error
+}
+
+// CollectionStore is a Store indexing records by their collections.
+type CollectionStore interface {
+	Store
+	// HeadsOf returns identifiers of records of a given collection, which have no successors.
+	HeadsOf(collection string) []ID
+	// Collections returns names of all collections with committed records, in lexicographical order.
+	Collections() []string
+}
+
+// SeqStore is a Store indexing sequenced records by their authors. It also rejects records, which
+// would leave a gap in the chain of their author's records, with SequenceGapError, and records, which
+// don't have a higher sequence number than their author's records they depend on, with SequenceOrderError.
+type SeqStore interface {
+	Store
+	// LastSeq returns the sequence number of the latest record of a given author in a given collection,
+	// or 0 if no sequenced records of that author have been committed there.
+	LastSeq(author AuthorId, collection string) uint64
+	// ByAuthor returns up to `take` records of a given author in a given collection starting at sequence
+	// number `seq`, in their sequence order.
+	ByAuthor(author AuthorId, collection string, seq uint64, take int) []*Record
+}
+
+// EquivocationStore is a Store detecting authors, who created concurrent records.
+type EquivocationStore interface {
+	Store
+	// Equivocations returns all evidence of authors creating concurrent records, in the order it was found.
+	Equivocations() []Equivocation
+	// Equivocated checks if there's an evidence of a given author creating concurrent records.
+	Equivocated(author AuthorId) bool
+}
+
+var (
+	_ PrunableStore     = (*MemStore)(nil)
+	_ CollectionStore   = (*MemStore)(nil)
+	_ SeqStore          = (*MemStore)(nil)
+	_ EquivocationStore = (*MemStore)(nil)
 )
 
+// MemStore is an in-memory implementation of a Store. It's safe for concurrent use.
 type MemStore struct {
-	log        []*Record      // ever-growing log of records, every new Commit is appended to the end and never deleted
+	mu         sync.RWMutex
+	log        []*Record      // ever-growing log of records, every new Commit is appended to the end and only replaced by a tombstone when pruned
 	index      map[string]int // index of patch.id to its location in the log
 	childrenOf [][]int        // a list from parent Record to its children descendants, by their log index position. Indexes of childrenOf match indexes of log
+
+	authors      map[string][]int    // log positions of the latest records of each author in each collection
+	evidence     []Equivocation      // evidence of authors creating concurrent records
+	equivocators map[string]struct{} // authors, who have created concurrent records
+	chains       map[string]*chain   // sequenced records of each author in each collection
+	collections  map[string]struct{} // names of collections with committed records
 }
 
 // NewMemStore returns a new empty MemStore.
@@ -25,14 +119,21 @@ func NewMemStore() *MemStore {
 		log:        []*Record{},
 		index:      make(map[string]int),
 		childrenOf: [][]int{},
+
+		authors:      make(map[string][]int),
+		equivocators: make(map[string]struct{}),
+		chains:       make(map[string]*chain),
+		collections:  make(map[string]struct{}),
 	}
 }
 
 // Get returns a Record identified by provided id. Returns nil if no Record with given id was found.
 func (ms *MemStore) Get(id ID) *Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	key := hex.EncodeToString(id)
 	i, found := ms.index[key]
-	if !found {
+	if !found || ms.log[i].Pruned() {
 		return nil
 	}
 	return ms.log[i]
@@ -41,17 +142,22 @@ func (ms *MemStore) Get(id ID) *Record {
 // GetMany returns a slice of records matching provided sequence of ids.
 // If a ID from provided input has not been found, it will be omitted from the result slice.
 func (ms *MemStore) GetMany(ids []ID) []*Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	res := make([]*Record, 0, len(ids))
 	for _, id := range ids {
 		key := hex.EncodeToString(id)
-		i := ms.index[key]
-		res = append(res, ms.log[i])
+		if i, found := ms.index[key]; found && !ms.log[i].Pruned() {
+			res = append(res, ms.log[i])
+		}
 	}
 	return res
 }
 
 // LatestN is a paging function, which returns the `take` latest integrated records, skiping the `skip` amount of them.
 func (ms *MemStore) LatestN(skip int, take int) []*Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	limit := len(ms.log)
 	start := limit - skip - take
 	if start < 0 {
@@ -61,12 +167,45 @@ func (ms *MemStore) LatestN(skip int, take int) []*Record {
 	if end < 0 {
 		return nil
 	}
-	res := ms.log[start:end]
+	res := make([]*Record, 0, end-start)
+	for _, r := range ms.log[start:end] {
+		if !r.Pruned() {
+			res = append(res, r)
+		}
+	}
 	return res
 }
 
+// Since returns up to `take` records starting at a given position in the log.
+func (ms *MemStore) Since(pos int, take int) []*Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= len(ms.log) || take <= 0 {
+		return nil
+	}
+	end := len(ms.log)
+	if end-pos > take {
+		end = pos + take
+	}
+	res := make([]*Record, end-pos)
+	copy(res, ms.log[pos:end])
+	return res
+}
+
+// Len returns the number of committed records, including the pruned ones.
+func (ms *MemStore) Len() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return len(ms.log)
+}
+
 // Heads recovers the most recent records that can serve as anchors for newly created records.
 func (ms *MemStore) Heads() []ID {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	var res []ID
 	for i, children := range ms.childrenOf {
 		if children == nil || len(children) == 0 {
@@ -78,6 +217,31 @@ func (ms *MemStore) Heads() []ID {
 	return res
 }
 
+// HeadsOf returns identifiers of records of a given collection, which have no successors.
+func (ms *MemStore) HeadsOf(collection string) []ID {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var res []ID
+	for i, children := range ms.childrenOf {
+		if len(children) == 0 && ms.log[i].coll == collection {
+			res = append(res, ms.log[i].id)
+		}
+	}
+	return res
+}
+
+// Collections returns names of all collections with committed records, in lexicographical order.
+func (ms *MemStore) Collections() []string {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	res := make([]string, 0, len(ms.collections))
+	for name := range ms.collections {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
 func (ms *MemStore) indexes(heads []ID) []int {
 	is := make([]int, 0, len(heads))
 	for _, h := range heads {
@@ -123,21 +287,27 @@ func (ms *MemStore) predecessorsF(heads []ID, f func(int, *Record)) Bitmap {
 }
 
 func (ms *MemStore) Predecessors(heads []ID) []*Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	var res []*Record
 	ms.predecessorsF(heads, func(i int, p *Record) {
-		res = append(res, p)
+		if !p.Pruned() {
+			res = append(res, p)
+		}
 	})
 	return res
 }
 
 // Missing returns a list of records that are successors or concurrent to given heads.
 func (ms *MemStore) Missing(heads []ID) []*Record {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	v := ms.predecessorsF(heads, func(i int, r *Record) {
 		/* do nothing */
 	})
 	var res []*Record
 	for i, p := range ms.log {
-		if !v.Get(i) {
+		if !v.Get(i) && !p.Pruned() {
 			res = append(res, p)
 		}
 	}
@@ -145,7 +315,22 @@ func (ms *MemStore) Missing(heads []ID) []*Record {
 }
 
 func (ms *MemStore) Commit(p *Record) error {
-	if err := p.Verify(); err != nil {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if err := ms.check(p); err != nil {
+		return err
+	}
+	ms.append(p)
+	return nil
+}
+
+// check verifies if a given Record can be committed into the store. Caller must hold at least a read lock.
+func (ms *MemStore) check(p *Record) error {
+	if p.Pruned() {
+		if len(p.id) != sha256.Size {
+			return HashLengthError // tombstones cannot be verified, only their shape
+		}
+	} else if err := p.Verify(); err != nil {
 		return err // invalid patch trying to be committed
 	}
 	cid := hex.EncodeToString(p.id)
@@ -154,10 +339,20 @@ func (ms *MemStore) Commit(p *Record) error {
 	}
 	for _, d := range p.deps {
 		k := hex.EncodeToString(d)
-		if _, found := ms.index[k]; !found {
+		i, found := ms.index[k]
+		if !found {
 			return DependencyNotFoundError
 		}
+		if ms.log[i].coll != p.coll {
+			return CollectionMismatchError // collections are replicated independently
+		}
 	}
+	return ms.checkSeq(p)
+}
+
+// append puts a Record, which has already passed the check, at the end of the log. Caller must hold a write lock.
+func (ms *MemStore) append(p *Record) {
+	cid := hex.EncodeToString(p.id)
 	i := len(ms.log)
 	ms.log = append(ms.log, p)
 	ms.childrenOf = append(ms.childrenOf, nil)
@@ -167,50 +362,35 @@ func (ms *MemStore) Commit(p *Record) error {
 		pi := ms.index[k]
 		ms.childrenOf[pi] = append(ms.childrenOf[pi], i)
 	}
-	return nil
+	ms.detect(i)
+	ms.chainSeq(i)
+	ms.collections[p.coll] = struct{}{}
 }
 
-func (ms *MemStore) Contains(id ID) bool {
-	_, ok := ms.index[hex.EncodeToString(id)]
-	return ok
-}
-
-type Stash struct {
-	log   []*Record
-	index map[string]int
-}
-
-func NewStash() *Stash {
-	return &Stash{
-		log:   []*Record{},
-		index: make(map[string]int),
-	}
+// Prune replaces records with given ids with their tombstones.
+func (ms *MemStore) Prune(ids []ID) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.log = ms.tombstones(ids)
+	return nil
 }
 
-func (s *Stash) Add(p *Record) {
-	id := hex.EncodeToString(p.id)
-	if _, found := s.index[id]; found {
-		return
+// tombstones returns a copy of the log, in which records with given ids are replaced with their
+// tombstones. Caller must hold at least a read lock.
+func (ms *MemStore) tombstones(ids []ID) []*Record {
+	log := make([]*Record, len(ms.log))
+	copy(log, ms.log)
+	for _, id := range ids {
+		if i, found := ms.index[hex.EncodeToString(id)]; found && !log[i].Pruned() {
+			log[i] = log[i].Tombstone()
+		}
 	}
-	i := len(s.log)
-	s.log = append(s.log, p)
-	s.index[id] = i
+	return log
 }
 
-func (s *Stash) Contains(id ID) bool {
-	_, ok := s.index[hex.EncodeToString(id)]
+func (ms *MemStore) Contains(id ID) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	_, ok := ms.index[hex.EncodeToString(id)]
 	return ok
 }
-
-// UnStash returns all logs and clears up current Stash.
-func (s *Stash) UnStash() []*Record {
-	res := s.log
-	// reverse the log order
-	for i := 0; i < len(res)/2; i++ {
-		j := len(res) - 1 - i
-		res[i], res[j] = res[j], res[i]
-	}
-	s.log = []*Record{}
-	s.index = make(map[string]int)
-	return res
-}
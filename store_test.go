@@ -1,9 +1,12 @@
 package bec
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"sync"
 	"testing"
 )
 
@@ -25,12 +28,54 @@ func testRecords(pub ed25519.PublicKey, priv ed25519.PrivateKey) []*Record {
 	}
 }
 
-func TestMemStoreCommitGet(t *testing.T) {
+// TestMemStore runs Store conformance suite against MemStore.
+func TestMemStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemStore()
+	})
+}
+
+// TestCoreStore runs Store conformance suite against a store implementing none of the optional interfaces.
+func TestCoreStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return struct{ Store }{NewMemStore()} // embedding the interface hides optional methods
+	})
+}
+
+// testStore is a conformance suite, which every Store implementation is expected to pass.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, newStore func(t *testing.T) Store)
+	}{
+		{"CommitGet", testStoreCommitGet},
+		{"CommitMissingDependency", testStoreCommitMissingDependency},
+		{"Pagination", testStorePagination},
+		{"Predecessors", testStorePredecessors},
+		{"PredecessorsMultiHeads", testStorePredecessorsMultiHeads},
+		{"Missing", testStoreMissing},
+		{"MissingMultiHeads", testStoreMissingMultiHeads},
+		{"ContainsHeads", testStoreContainsHeads},
+		{"Since", testStoreSince},
+		{"Prune", testStorePrune},
+		{"Concurrent", testStoreConcurrent},
+		{"Equivocation", testStoreEquivocation},
+		{"Sequence", testStoreSequence},
+		{"Collections", testStoreCollections},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore)
+		})
+	}
+}
+
+func testStoreCommitGet(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	for _, p := range records {
 		if err := ms.Commit(p); err != nil {
@@ -45,12 +90,12 @@ func TestMemStoreCommitGet(t *testing.T) {
 	}
 }
 
-func TestMemStoreCommitMissingDependency(t *testing.T) {
+func testStoreCommitMissingDependency(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	const Removed = 4
 	records = append(records[:Removed], records[Removed+1:]...)
@@ -65,12 +110,12 @@ func TestMemStoreCommitMissingDependency(t *testing.T) {
 	}
 }
 
-func TestMemStorePagination(t *testing.T) {
+func testStorePagination(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	for _, p := range records {
 		if err := ms.Commit(p); err != nil {
@@ -106,12 +151,12 @@ func TestMemStorePagination(t *testing.T) {
 	}
 }
 
-func TestMemStorePredecessors(t *testing.T) {
+func testStorePredecessors(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	for _, p := range records {
 		if err := ms.Commit(p); err != nil {
@@ -134,12 +179,12 @@ func TestMemStorePredecessors(t *testing.T) {
 	}
 }
 
-func TestMemStorePredecessorsMultiHeads(t *testing.T) {
+func testStorePredecessorsMultiHeads(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	for _, p := range records {
 		if err := ms.Commit(p); err != nil {
@@ -163,12 +208,12 @@ func TestMemStorePredecessorsMultiHeads(t *testing.T) {
 	}
 }
 
-func TestMemStoreMissing(t *testing.T) {
+func testStoreMissing(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	for _, p := range records {
 		if err := ms.Commit(p); err != nil {
@@ -189,12 +234,12 @@ func TestMemStoreMissing(t *testing.T) {
 	}
 }
 
-func TestMemStoreMissingMultiHeads(t *testing.T) {
+func testStoreMissingMultiHeads(t *testing.T, newStore func(t *testing.T) Store) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	ms := NewMemStore()
+	ms := newStore(t)
 	records := testRecords(pub, priv)
 	for _, p := range records {
 		if err := ms.Commit(p); err != nil {
@@ -215,3 +260,348 @@ func TestMemStoreMissingMultiHeads(t *testing.T) {
 		}
 	}
 }
+
+func testStoreContainsHeads(t *testing.T, newStore func(t *testing.T) Store) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms := newStore(t)
+	records := testRecords(pub, priv)
+	for _, p := range records {
+		if err := ms.Commit(p); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for _, p := range records {
+		if !ms.Contains(p.id) {
+			t.Fatalf("store doesn't contain committed record %s", hex.EncodeToString(p.id))
+		}
+	}
+	if err := ms.Commit(records[0]); err != AlreadyCommittedError {
+		t.Fatalf("expected duplicate commit to fail, got: %v", err)
+	}
+	other := NewRecord(pub, priv, nil, []byte("X"))
+	if ms.Contains(other.id) || ms.Get(other.id) != nil {
+		t.Fatalf("store contains record that was never committed")
+	}
+	if res := ms.GetMany([]ID{records[1].id, other.id}); len(res) != 1 || res[0] != records[1] {
+		t.Fatalf("GetMany should omit records that were not found")
+	}
+	heads := ms.Heads()
+	if len(heads) != 2 {
+		t.Fatalf("expected 2 heads, found %d", len(heads))
+	}
+	for _, h := range heads {
+		if !bytes.Equal(h, records[3].id) && !bytes.Equal(h, records[5].id) {
+			t.Fatalf("unexpected head %s", hex.EncodeToString(h))
+		}
+	}
+}
+
+func testStoreSince(t *testing.T, newStore func(t *testing.T) Store) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms := newStore(t)
+	records := testRecords(pub, priv)
+	for _, p := range records {
+		if err := ms.Commit(p); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if ms.Len() != len(records) {
+		t.Fatalf("expected %d records, found %d", len(records), ms.Len())
+	}
+	res := ms.Since(2, 3)
+	if len(res) != 3 || res[0] != records[2] || res[2] != records[4] {
+		t.Fatalf("unexpected records returned from the middle of the log")
+	}
+	if res = ms.Since(4, 10); len(res) != 2 || res[1] != records[5] {
+		t.Fatalf("unexpected records returned from the end of the log")
+	}
+	if res = ms.Since(len(records), 10); len(res) != 0 {
+		t.Fatalf("expected no records past the end of the log")
+	}
+}
+
+func testStorePrune(t *testing.T, newStore func(t *testing.T) Store) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms, ok := newStore(t).(PrunableStore)
+	if !ok {
+		t.Skip("store doesn't implement PrunableStore")
+	}
+	records := testRecords(pub, priv)
+	for _, p := range records {
+		if err := ms.Commit(p); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	a, b, c, d, e, f := records[0], records[1], records[2], records[3], records[4], records[5]
+	if err := ms.Prune([]ID{a.id, b.id}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !ms.Contains(a.id) || !ms.Contains(b.id) {
+		t.Fatalf("pruned records should still be committed")
+	}
+	if ms.Get(a.id) != nil || len(ms.GetMany([]ID{a.id, b.id})) != 0 {
+		t.Fatalf("pruned records should not be returned")
+	}
+	if res := ms.Predecessors([]ID{d.id}); len(res) != 1 || res[0] != d {
+		t.Fatalf("expected only D to be returned from its predecessors")
+	}
+	if res := ms.Predecessors([]ID{e.id}); len(res) != 2 {
+		t.Fatalf("expected predecessors to be traversed through tombstones, got %d records", len(res))
+	}
+	if res := ms.Missing(nil); !sameRecords(res, []*Record{c, d, e, f}) {
+		t.Fatalf("unexpected records left after pruning")
+	}
+	if ms.Len() != len(records) || !ms.Since(0, 1)[0].Pruned() {
+		t.Fatalf("pruned records should keep their log positions")
+	}
+	if err := ms.Commit(a); err != AlreadyCommittedError {
+		t.Fatalf("expected pruned record not to be committed again, got: %v", err)
+	}
+
+	// tombstones can be committed in place of records, which were pruned elsewhere
+	other := newStore(t)
+	for _, r := range []*Record{a.Tombstone(), b.Tombstone(), c, d} {
+		if err := other.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if res := other.Missing(nil); !sameRecords(res, []*Record{c, d}) {
+		t.Fatalf("unexpected records committed after tombstones")
+	}
+}
+
+func testStoreConcurrent(t *testing.T, newStore func(t *testing.T) Store) {
+	const writers, n = 8, 50
+	ms := newStore(t)
+	chains := make([][]*Record, writers)
+	for i := range chains {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		var deps []ID
+		for j := 0; j < n; j++ {
+			r := NewRecord(pub, priv, deps, []byte{byte(j)})
+			chains[i] = append(chains[i], r)
+			deps = []ID{r.id}
+		}
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, writers)
+	for _, chain := range chains {
+		wg.Add(1)
+		go func(chain []*Record) {
+			defer wg.Done()
+			for _, r := range chain {
+				if err := ms.Commit(r); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(chain)
+	}
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				heads := ms.Heads()
+				ms.GetMany(heads)
+				ms.Predecessors(heads)
+				ms.Missing(heads)
+				ms.LatestN(0, 10)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent commit failed: %s", err.Error())
+	}
+
+	if res := ms.Missing(nil); len(res) != writers*n {
+		t.Fatalf("expected %d records, found %d", writers*n, len(res))
+	}
+	heads := ms.Heads()
+	if len(heads) != writers {
+		t.Fatalf("expected %d heads, found %d", writers, len(heads))
+	}
+	for _, chain := range chains {
+		if !ms.Contains(chain[n-1].id) {
+			t.Fatalf("store doesn't contain head %s", hex.EncodeToString(chain[n-1].id))
+		}
+	}
+}
+
+func testStoreEquivocation(t *testing.T, newStore func(t *testing.T) Store) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	honestPub, honestPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms, ok := newStore(t).(EquivocationStore)
+	if !ok {
+		t.Skip("store doesn't implement EquivocationStore")
+	}
+	a := NewRecord(honestPub, honestPriv, nil, []byte("A"))
+	b := NewRecord(pub, priv, []ID{a.id}, []byte("B"))
+	c := NewRecord(honestPub, honestPriv, []ID{a.id}, []byte("C"))
+	d := NewRecord(honestPub, honestPriv, []ID{b.id, c.id}, []byte("D"))
+	for _, r := range []*Record{a, b, c, d} {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if len(ms.Equivocations()) != 0 || ms.Equivocated(pub) || ms.Equivocated(honestPub) {
+		t.Fatalf("expected no equivocations when records depend on previous ones")
+	}
+
+	e := NewRecord(pub, priv, []ID{a.id}, []byte("E"))
+	f := NewRecord(pub, priv, []ID{e.id}, []byte("F"))
+	for _, r := range []*Record{e, f} {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	res := ms.Equivocations()
+	if len(res) != 1 || res[0].First != b || res[0].Second != e {
+		t.Fatalf("expected a single evidence of concurrent records, got: %v", res)
+	}
+	if !bytes.Equal(res[0].Author(), pub) || !ms.Equivocated(pub) || ms.Equivocated(honestPub) {
+		t.Fatalf("expected only the author of concurrent records to equivocate")
+	}
+}
+
+func testStoreSequence(t *testing.T, newStore func(t *testing.T) Store) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms, ok := newStore(t).(SeqStore)
+	if !ok {
+		t.Skip("store doesn't implement SeqStore")
+	}
+	a := NewSequencedRecord(pub, priv, 1, nil, []byte("A"))
+	b := NewSequencedRecord(pub, priv, 2, []ID{a.id}, []byte("B"))
+	c := NewRecord(pub, priv, []ID{b.id}, []byte("C"))
+	d := NewSequencedRecord(pub, priv, 3, []ID{c.id}, []byte("D"))
+	for _, r := range []*Record{a, b, c, d} {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if seq := ms.LastSeq(pub, ""); seq != 3 {
+		t.Fatalf("expected last sequence number 3, got %d", seq)
+	}
+	if res := ms.ByAuthor(pub, "", 2, 10); len(res) != 2 || res[0] != b || res[1] != d {
+		t.Fatalf("unexpected records of the author: %v", res)
+	}
+	if res := ms.ByAuthor(pub, "", 1, 1); len(res) != 1 || res[0] != a {
+		t.Fatalf("expected records to be limited")
+	}
+
+	gap := NewSequencedRecord(pub, priv, 5, []ID{d.id}, []byte("gap"))
+	if err := ms.Commit(gap); !errors.Is(err, SequenceGapError) {
+		t.Fatalf("expected sequence gap to be rejected, got: %v", err)
+	}
+	for _, seq := range []uint64{2, 3} {
+		stale := NewSequencedRecord(pub, priv, seq, []ID{d.id}, []byte("stale"))
+		if err := ms.Commit(stale); !errors.Is(err, SequenceOrderError) {
+			t.Fatalf("expected sequence number %d following 3 to be rejected, got: %v", seq, err)
+		}
+	}
+	pub2, priv2, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := ms.Commit(NewSequencedRecord(pub2, priv2, 1000, []ID{d.id}, []byte("late"))); !errors.Is(err, SequenceGapError) {
+		t.Fatalf("expected first record of an author not starting at 1 to be rejected, got: %v", err)
+	}
+	// concurrent record reusing a sequence number is committed, but doesn't replace the original one
+	fork := NewSequencedRecord(pub, priv, 3, []ID{c.id}, []byte("fork"))
+	if err := ms.Commit(fork); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if res := ms.ByAuthor(pub, "", 3, 10); len(res) != 1 || res[0] != d {
+		t.Fatalf("expected the first record to keep its sequence number")
+	}
+
+	ps, ok := ms.(PrunableStore)
+	if !ok {
+		return // the rest requires pruning
+	}
+	if err := ps.Prune([]ID{d.id}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	e := NewSequencedRecord(pub, priv, 4, []ID{d.id, fork.id}, []byte("E"))
+	if err := ms.Commit(e); err != nil {
+		t.Fatalf("expected pruned records to stay in the chain, got: %v", err)
+	}
+	if res := ms.ByAuthor(pub, "", 3, 10); len(res) != 1 || res[0] != e {
+		t.Fatalf("expected pruned records to be skipped")
+	}
+}
+
+func testStoreCollections(t *testing.T, newStore func(t *testing.T) Store) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ms, ok := newStore(t).(CollectionStore)
+	if !ok {
+		t.Skip("store doesn't implement CollectionStore")
+	}
+	record := func(coll string, deps []ID, data string) *Record {
+		r := &Record{version: LatestRecordVersion, author: pub, deps: deps, data: []byte(data), coll: coll}
+		return r.seal(priv)
+	}
+	a := record("x", nil, "A")
+	b := record("y", nil, "B")
+	c := record("x", []ID{a.id}, "C")
+	for _, r := range []*Record{a, b, c} {
+		if err := ms.Commit(r); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if !sameIDs(ms.HeadsOf("x"), []ID{c.id}) || !sameIDs(ms.HeadsOf("y"), []ID{b.id}) || len(ms.HeadsOf("")) != 0 {
+		t.Fatalf("unexpected heads of collections")
+	}
+	if names := ms.Collections(); len(names) != 2 || names[0] != "x" || names[1] != "y" {
+		t.Fatalf("unexpected collections: %v", names)
+	}
+	if err := ms.Commit(record("y", []ID{c.id}, "D")); err != CollectionMismatchError {
+		t.Fatalf("expected record depending on another collection to be rejected, got: %v", err)
+	}
+	ps, ok := ms.(PrunableStore)
+	if !ok {
+		return // the rest requires pruning
+	}
+	if err := ps.Prune([]ID{c.id}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !sameIDs(ms.HeadsOf("x"), []ID{c.id}) {
+		t.Fatalf("expected tombstones to keep their collection")
+	}
+}
@@ -0,0 +1,80 @@
+package bec
+
+import "sync"
+
+// subscriptionBatch is the maximum number of records read from the store at once by a Subscription.
+const subscriptionBatch = 256
+
+// Cursor is a position in the store log. Since records are never removed from the log (pruned ones
+// leave their tombstones behind), it can be used to resume a Subscription from the point where the
+// previous one stopped.
+type Cursor uint64
+
+// Update is a committed Record delivered by a Subscription.
+type Update struct {
+	Record *Record
+	Cursor Cursor // position right after Record, subscribing from it delivers the records following this one
+}
+
+// Subscription delivers records committed into a Peer store in their causal order.
+type Subscription struct {
+	C    <-chan Update // updates in their commit order, closed once the Subscription is closed
+	done chan struct{}
+	once sync.Once
+}
+
+// Close stops the Subscription. Updates which have not been received are dropped.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// Cursor returns the current end of the store log. Subscribing from it delivers only records
+// committed afterwards.
+func (p *Peer) Cursor() Cursor {
+	return Cursor(p.store.Len())
+}
+
+// Subscribe returns a Subscription delivering every record committed into a Peer store starting from
+// a given log position, no matter if it was created by Commit or received through Integrate. Records
+// already committed at that position are delivered first, followed by new ones as they get committed.
+// Pruned records are skipped. Slow subscribers don't block the Peer, as records are read from the
+// store lazily.
+func (p *Peer) Subscribe(from Cursor) *Subscription {
+	c := make(chan Update)
+	s := &Subscription{C: c, done: make(chan struct{})}
+	go p.feed(s, c, from)
+	return s
+}
+
+func (p *Peer) feed(s *Subscription, c chan<- Update, pos Cursor) {
+	defer close(c)
+	for {
+		// grab the signal before reading the store, so that no commit can be missed in between
+		p.mu.RLock()
+		updated := p.updated
+		p.mu.RUnlock()
+
+		rs := p.store.Since(int(pos), subscriptionBatch)
+		for _, r := range rs {
+			pos++
+			if r.Pruned() {
+				continue
+			}
+			select {
+			case c <- Update{Record: r, Cursor: pos}:
+			case <-s.done:
+				return
+			}
+		}
+		if len(rs) > 0 {
+			continue
+		}
+		select {
+		case <-updated:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package bec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// receive waits for n updates from a subscription.
+func receive(t *testing.T, s *Subscription, n int) []Update {
+	var res []Update
+	for len(res) < n {
+		select {
+		case u, ok := <-s.C:
+			if !ok {
+				t.Fatalf("subscription closed after %d updates", len(res))
+			}
+			res = append(res, u)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after receiving %d updates", len(res))
+		}
+	}
+	return res
+}
+
+func TestSubscribe(t *testing.T) {
+	p := newTestPeer(t)
+	remote := newTestPeer(t)
+	s := p.Subscribe(0)
+	defer s.Close()
+
+	local, err := p.Commit([]byte("A"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	records := testRecords(remote.pub, remote.priv)
+	// integrate out of order: stashed records are delivered once committed
+	if err = p.Integrate([]*Record{records[5], records[4], records[0], records[1], records[2], records[3]}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	updates := receive(t, s, 1+len(records))
+	if updates[0].Record != local {
+		t.Fatalf("expected locally committed record first")
+	}
+	seen := map[string]struct{}{}
+	for i, u := range updates {
+		if u.Cursor != Cursor(i+1) {
+			t.Fatalf("expected cursor %d, got %d", i+1, u.Cursor)
+		}
+		for _, dep := range u.Record.Deps() {
+			if _, ok := seen[string(dep)]; !ok {
+				t.Fatalf("record delivered before its dependency")
+			}
+		}
+		seen[string(u.Record.ID())] = struct{}{}
+	}
+	select {
+	case u := <-s.C:
+		t.Fatalf("unexpected update: %s", u.Record.Data())
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSubscribeResume(t *testing.T) {
+	p := newTestPeer(t)
+	for _, data := range []string{"A", "B", "C"} {
+		if _, err := p.Commit([]byte(data)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	s := p.Subscribe(0)
+	cursor := receive(t, s, 2)[1].Cursor
+	s.Close()
+	for range s.C {
+		// drain until the subscription stops
+	}
+
+	s = p.Subscribe(cursor)
+	defer s.Close()
+	if u := receive(t, s, 1)[0]; !bytes.Equal(u.Record.Data(), []byte("C")) {
+		t.Fatalf("expected to resume from C, got: %s", u.Record.Data())
+	}
+	if _, err := p.Commit([]byte("D")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if u := receive(t, s, 1)[0]; !bytes.Equal(u.Record.Data(), []byte("D")) || u.Cursor != p.Cursor() {
+		t.Fatalf("expected to receive D at the end of the log, got: %s", u.Record.Data())
+	}
+}
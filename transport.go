@@ -0,0 +1,193 @@
+package bec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxFrameSize is the maximum size of a frame payload used when TransportConfig doesn't specify one.
+const DefaultMaxFrameSize = 16 << 20
+
+var (
+	// FrameTooLargeError happens when a frame payload exceeds the maximum allowed size.
+	FrameTooLargeError = fmt.Errorf("frame exceeds maximum allowed size")
+
+	// EmptyFrameError happens when trying to write a message without a message type.
+	EmptyFrameError = fmt.Errorf("message has no type")
+)
+
+// WriteFrame writes a single frame: message type byte followed by a varint length of the payload
+// and the payload itself.
+func WriteFrame(w io.Writer, msgType byte, payload []byte) error {
+	var inlined [1 + binary.MaxVarintLen64]byte
+	buf := inlined[:]
+	buf[0] = msgType
+	n := binary.PutUvarint(buf[1:], uint64(len(payload)))
+	if _, err := w.Write(buf[:1+n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame reads a single frame written by WriteFrame, returning its message type and payload.
+// Frames with payload larger than max bytes are rejected with FrameTooLargeError without reading
+// their payload. Returns io.EOF if reader ended before the frame and TruncatedError if it
+// ended in the middle of it.
+func ReadFrame(r *bufio.Reader, max int) (byte, []byte, error) {
+	msgType, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, truncated(err)
+	}
+	if n > uint64(max) {
+		return 0, nil, FrameTooLargeError
+	}
+	payload := make([]byte, int(n))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, truncated(err)
+	}
+	return msgType, payload, nil
+}
+
+// TransportConfig describes how PeerController communicates over a network connection.
+type TransportConfig struct {
+	// ReadTimeout is the maximum time to read a single frame, once it started arriving. Zero means no timeout.
+	ReadTimeout time.Duration
+	// IdleTimeout is the maximum time to wait for the next frame to start arriving. Connection is closed
+	// once it's exceeded, but it's not considered a failure of the remote. Zero means no timeout.
+	IdleTimeout time.Duration
+	// WriteTimeout is the maximum time to write a single frame. Zero means no timeout.
+	WriteTimeout time.Duration
+	// MaxFrameSize is the maximum accepted frame payload. Zero means DefaultMaxFrameSize.
+	MaxFrameSize int
+}
+
+// Serve runs the replication protocol with a remote peer over a given connection, registering it
+// under a given name. It blocks until either the connection fails or the remote gets disconnected,
+// and closes the connection before returning. Graceful close of the connection by the remote peer
+// is not reported as an error. Timeouts are reported, but they don't count as failures of the remote,
+// since they are caused by the network just as often.
+func (c *PeerController) Serve(name string, conn net.Conn, cfg TransportConfig) error {
+	defer conn.Close()
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = DefaultMaxFrameSize
+	}
+	in := make(chan []byte)
+	out := make(chan []byte)
+	if err := c.Connect(name, in, out); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(in) // disconnects the remote
+		readErr = readFrames(conn, cfg, in, stop)
+	}()
+
+	w := bufio.NewWriter(conn)
+	var writeErr error
+	for msg := range out {
+		if writeErr != nil {
+			continue // keep draining until controller closes the channel
+		}
+		if writeErr = writeFrame(conn, w, cfg, msg); writeErr != nil {
+			conn.Close() // reader fails and disconnects the remote
+		}
+	}
+	close(stop)
+	conn.Close() // unblock reader
+	wg.Wait()
+
+	if !closedErr(readErr) {
+		if !timeoutErr(readErr) {
+			c.fail(name)
+		}
+		if o, ok := classify(readErr); ok {
+			c.penalize(name, o, 1) // malformed frame
+		}
+		return readErr
+	}
+	if !closedErr(writeErr) {
+		if !timeoutErr(writeErr) {
+			c.fail(name)
+		}
+		return writeErr
+	}
+	return nil
+}
+
+// closedErr checks if err is nil or caused only by the connection being closed.
+func closedErr(err error) bool {
+	return err == nil || err == io.EOF || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// timeoutErr checks if err has been caused by exceeding a connection deadline.
+func timeoutErr(err error) bool {
+	var ne net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// setReadDeadline makes reads from a connection fail after a given timeout, zero meaning no timeout.
+func setReadDeadline(conn net.Conn, timeout time.Duration) error {
+	if timeout <= 0 {
+		return conn.SetReadDeadline(time.Time{})
+	}
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func readFrames(conn net.Conn, cfg TransportConfig, in chan<- []byte, stop <-chan struct{}) error {
+	r := bufio.NewReader(conn)
+	for {
+		// quiet connections are healthy, so waiting for the next frame is bounded only by IdleTimeout
+		if err := setReadDeadline(conn, cfg.IdleTimeout); err != nil {
+			return err
+		}
+		if _, err := r.Peek(1); err != nil {
+			return err
+		}
+		if err := setReadDeadline(conn, cfg.ReadTimeout); err != nil {
+			return err
+		}
+		msgType, payload, err := ReadFrame(r, cfg.MaxFrameSize)
+		if err != nil {
+			return err
+		}
+		msg := make([]byte, 0, len(payload)+1)
+		msg = append(append(msg, msgType), payload...)
+		select {
+		case in <- msg:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func writeFrame(conn net.Conn, w *bufio.Writer, cfg TransportConfig, msg []byte) error {
+	if len(msg) == 0 {
+		return EmptyFrameError
+	}
+	if cfg.WriteTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+	if err := WriteFrame(w, msg[0], msg[1:]); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,144 @@
+package bec
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, MsgRequest, []byte("hello")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := WriteFrame(&buf, MsgAnnounce, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	r := bufio.NewReader(&buf)
+	msgType, payload, err := ReadFrame(r, 16)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if msgType != MsgRequest || string(payload) != "hello" {
+		t.Fatalf("unexpected frame: %d %q", msgType, payload)
+	}
+	msgType, payload, err = ReadFrame(r, 16)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if msgType != MsgAnnounce || len(payload) != 0 {
+		t.Fatalf("unexpected frame: %d %q", msgType, payload)
+	}
+	if _, _, err = ReadFrame(r, 16); err != io.EOF {
+		t.Fatalf("expected EOF, got: %v", err)
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, MsgRecords, make([]byte, 100)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data := buf.Bytes()
+	if _, _, err := ReadFrame(bufio.NewReader(bytes.NewReader(data)), 99); err != FrameTooLargeError {
+		t.Fatalf("expected frame to be too large, got: %v", err)
+	}
+	if _, _, err := ReadFrame(bufio.NewReader(bytes.NewReader(data[:50])), 100); err != TruncatedError {
+		t.Fatalf("expected truncated frame, got: %v", err)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	p1 := newTestPeer(t)
+	p2 := newTestPeer(t)
+	if err := p1.Integrate(testRecords(p1.pub, p1.priv)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := p2.Commit([]byte("G")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	c1 := NewController(p1)
+	c2 := NewController(p2)
+	go c1.Process()
+	go c2.Process()
+
+	cfg := TransportConfig{ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
+	conn1, conn2 := net.Pipe()
+	done := make(chan error, 2)
+	go func() { done <- c1.Serve("p2", conn1, cfg) }()
+	go func() { done <- c2.Serve("p1", conn2, cfg) }()
+
+	awaitConvergence(t, c1, c2)
+
+	// disconnecting one side closes the connection for both
+	c1.Disconnect("p2")
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("serve failed: %s", err.Error())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection was not closed in time")
+		}
+	}
+	c1.Close()
+	c2.Close()
+	compareStores(p1.store, p2.store, t)
+}
+
+func TestServeOversizedFrame(t *testing.T) {
+	c := NewController(newTestPeer(t))
+	go c.Process()
+	defer c.Close()
+
+	conn1, conn2 := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- c.Serve("remote", conn1, TransportConfig{MaxFrameSize: 64}) }()
+	go io.Copy(io.Discard, conn2)
+	go WriteFrame(conn2, MsgRecords, make([]byte, 65)) // fails once the connection gets closed
+	select {
+	case err := <-done:
+		if err != FrameTooLargeError {
+			t.Fatalf("expected frame to be rejected, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection was not closed in time")
+	}
+}
+
+func TestServeTimeouts(t *testing.T) {
+	c := NewController(newTestPeer(t), WithGossip(DefaultGossipConfig))
+	go c.Process()
+	defer c.Close()
+
+	conn1, conn2 := net.Pipe()
+	done := make(chan error, 1)
+	cfg := TransportConfig{ReadTimeout: 20 * time.Millisecond, IdleTimeout: 200 * time.Millisecond}
+	go func() { done <- c.Serve("remote", conn1, cfg) }()
+	go io.Copy(io.Discard, conn2)
+
+	// read timeout doesn't apply while waiting for the next frame
+	time.Sleep(100 * time.Millisecond)
+	if err := WriteFrame(conn2, MsgAnnounce, []byte{0}); err != nil {
+		t.Fatalf("expected idle connection to stay open, got: %v", err)
+	}
+	select {
+	case err := <-done:
+		if !timeoutErr(err) {
+			t.Fatalf("expected idle timeout, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection was not closed in time")
+	}
+	c.mu.Lock()
+	failures := c.healthOf("remote").failures
+	c.mu.Unlock()
+	if failures != 0 {
+		t.Fatalf("expected timeout not to count as a failure, got: %d", failures)
+	}
+}